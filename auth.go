@@ -24,18 +24,101 @@
 package torc
 
 import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
     "fmt"
     "io/ioutil"
+    "strings"
 )
 
-type AuthResponse struct { *BaseControlResponse }
+// HMAC keys used by SAFECOOKIE authentication, as defined by the control spec.
+const (
+    SAFECOOKIE_SERVER_KEY = "Tor safe cookie authentication server-to-controller hash"
+    SAFECOOKIE_CLIENT_KEY = "Tor safe cookie authentication controller-to-server hash"
+)
+
+// Length in bytes of authentication cookies and SAFECOOKIE nonces.
+const AUTH_COOKIE_LENGTH = 32
+
+type AuthResponse struct { BaseControlResponse }
 
 // The Authenticator interface defines the API for plugin authentication modules
 // to implement. When adding authentication modules, make sure they're
 // registered in the Controller before application calls "Connect()"
 type Authenticator interface {
     MethodName() string
-    Authenticate(*Controller, *ProtocolInfoResponse) error
+    Authenticate(context.Context, *Controller, *ProtocolInfoResponse) error
+}
+
+// Returns the built-in authentication modules in default order of preference.
+func DefaultAuthenticators() []Authenticator {
+    return []Authenticator{
+        &SafeCookieAuthenticator{},
+        &CookieAuthenticator{},
+        &PasswordAuthenticator{},
+        &OpenAuthenticator{},
+    }
+}
+
+// Returns the authentication modules tried by Connect(), in order of
+// preference.
+func (c *Controller) Authenticators() []Authenticator {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    return append([]Authenticator(nil), c.authenticators...)
+}
+
+// Replaces the authentication modules tried by Connect() with authenticators,
+// in order of preference. Methods advertised by Tor without a module listed
+// here are never attempted, so this may also be used to restrict them.
+func (c *Controller) SetAuthenticators(authenticators ...Authenticator) {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    c.authenticators = append([]Authenticator(nil), authenticators...)
+}
+
+// Registers authenticator as the most preferred authentication module,
+// replacing any module registered for the same method.
+func (c *Controller) RegisterAuthenticator(authenticator Authenticator) {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+
+    results := []Authenticator{authenticator}
+    for _, v := range c.authenticators {
+        if v.MethodName() == authenticator.MethodName() { continue }
+        results = append(results, v)
+    }
+    c.authenticators = results
+}
+
+// Returns the authentication module used by the current connection.
+func (c *Controller) Authenticator() Authenticator {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    return c.authenticator
+}
+
+// Replaces the Controller's PasswordProvider. Unlike assigning the field, it's
+// safe while connected, taking effect on the next authentication.
+func (c *Controller) SetPasswordProvider(provider PasswordProvider) {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    c.PasswordProvider = provider
+}
+
+// Returns the Controller's password source, PasswordProvider when set,
+// otherwise the Password field, nil when neither is set.
+func (c *Controller) passwordProvider() PasswordProvider {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    if c.PasswordProvider != nil { return c.PasswordProvider }
+    if c.Password != "" { return StaticPasswordProvider(c.Password.Reveal()) }
+    return nil
 }
 
 
@@ -45,21 +128,15 @@ type OpenAuthenticator struct {}
 
 func (a *OpenAuthenticator) MethodName() string { return "NULL" }
 
-func (a *OpenAuthenticator) Authenticate(c *Controller, protoinfo *ProtocolInfoResponse) error {
-    LogInfo("Attempting open authentication...")
+func (a *OpenAuthenticator) Authenticate(ctx context.Context, c *Controller, protoinfo *ProtocolInfoResponse) error {
+    c.logger().Debug("Attempting open authentication.")
 
-    response := &AuthResponse{}
-    e := c.Request(NewRequest(COMMAND_AUTHENTICATE), response)
+    _, e := Do[AuthResponse](ctx, c, NewRequest(COMMAND_AUTHENTICATE))
     if e != nil {
-        LogWarn("Failed to send request: %v", e)
+        c.logger().Warn("AUTHENTICATE request failed.", "error", e)
         return e
     }
 
-    c.isAuthenticated = response.IsSuccess()
-    if !c.IsAuthenticated() {
-        return fmt.Errorf("Authentication failed!")
-    }
-
     return nil
 }
 
@@ -69,65 +146,216 @@ type CookieAuthenticator struct {}
 
 func (a *CookieAuthenticator) MethodName() string { return "COOKIE" }
 
-func (a *CookieAuthenticator) Authenticate(c *Controller, protoinfo *ProtocolInfoResponse) error {
-    LogInfo("Attempting cookie authentication...")
+func (a *CookieAuthenticator) Authenticate(ctx context.Context, c *Controller, protoinfo *ProtocolInfoResponse) error {
+    c.logger().Debug("Attempting cookie authentication.")
+
+    path, ok := protoinfo.AuthCookieFile()
+    if !ok { return fmt.Errorf("No cookie file advertised") }
 
-    cookie, e := ioutil.ReadFile(protoinfo.AuthCookieFile())
+    cookie, e := ioutil.ReadFile(path)
     if e != nil {
-        LogError("Failed to read cookie: %v", e)
+        c.logger().Error("Failed to read cookie.", "error", e)
         return e
     }
 
-    response := &AuthResponse{}
-    e = c.Request(NewRequest(fmt.Sprintf("AUTHENTICATE %x", cookie)), response)
-    if e != nil || !response.IsSuccess() {
-        LogWarn("Failed to send request: %v", e)
-        return e
-    }
+    request, e := NewRequestBuilder(COMMAND_AUTHENTICATE).Arg(hex.EncodeToString(cookie)).Build()
+    if e != nil { return e }
 
-    c.isAuthenticated = response.IsSuccess()
-    if !c.IsAuthenticated() {
-        return fmt.Errorf("Authentication failed!")
+    _, e = Do[AuthResponse](ctx, c, request)
+    if e != nil {
+        c.logger().Warn("AUTHENTICATE request failed.", "error", e)
+        return e
     }
 
     return nil
 }
 
 // A PasswordAuthenticator implements the Authenticator interface to provide
-// password based authentication to the control socket.
-type PasswordAuthenticator struct { }
+// password based authentication to the control socket. The password is fetched
+// from Provider when set, otherwise from the Controller's PasswordProvider, and
+// finally from its Password field, and when none is set ErrNoPassword is
+// returned without sending anything. It's fetched anew for every attempt, so
+// rotated passwords are picked up on reconnect.
+type PasswordAuthenticator struct {
+    Provider PasswordProvider
+}
 
 func (a *PasswordAuthenticator) MethodName() string { return "HASHEDPASSWORD" }
 
-func (a *PasswordAuthenticator) Authenticate(c *Controller, protoinfo *ProtocolInfoResponse) error {
-    LogInfo("Attempting password authentication...")
+func (a *PasswordAuthenticator) Authenticate(ctx context.Context, c *Controller, protoinfo *ProtocolInfoResponse) error {
+    c.logger().Debug("Attempting password authentication.")
 
-    response := &AuthResponse{}
-    e := c.Request(NewRequest(fmt.Sprintf("AUTHENTICATE \"%s\"", c.Password)), response)
-    if e != nil || !response.IsSuccess() {
-        LogWarn("Failed to send request: %v", e)
+    provider := a.Provider
+    if provider == nil { provider = c.passwordProvider() }
+    if provider == nil { return ErrNoPassword }
+
+    password, e := provider.Password(ctx)
+    if e != nil {
+        c.logger().Error("Failed to fetch password.", "error", e)
         return e
     }
 
-    c.isAuthenticated = response.IsSuccess()
-    if !c.IsAuthenticated() {
-        return fmt.Errorf("Authentication failed!")
+    // Send the password hex encoded, so it needs no quoting. The line is built
+    // and written as bytes, so no copy outlives the request.
+    prefix := COMMAND_AUTHENTICATE + " "
+    line := make([]byte, len(prefix) + hex.EncodedLen(len(password)) + 2)
+    copy(line, prefix)
+    hex.Encode(line[len(prefix):], password)
+    copy(line[len(line) - 2:], "\r\n")
+    zero(password)
+    defer zero(line)
+
+    // The request's line is zeroed on return, so keep it out of the error too.
+    _, e = Do[AuthResponse](ctx, c, &rawRequest{COMMAND_AUTHENTICATE, line})
+    if e != nil {
+        var reply *ReplyError
+        if errors.As(e, &reply) { reply.Request = nil }
+        var timeout *RequestTimeoutError
+        if errors.As(e, &timeout) { timeout.Request = nil }
+
+        c.logger().Warn("AUTHENTICATE request failed.", "error", e)
+        return e
     }
 
     return nil
 }
 
+// Parameters of the RFC 2440 iterated and salted S2K specifier used by Tor's
+// HashedControlPassword option. An indicator of 96 iterates 65536 bytes.
+const (
+    S2K_SALT_LENGTH = 8
+    S2K_INDICATOR   = 96
+    S2K_PREFIX      = "16:"
+)
+
+// Hashes password for use with Tor's HashedControlPassword option, equivalent
+// to "tor --hash-password". A random salt is used, so each call returns a
+// different hash.
+func HashPassword(password []byte) (string, error) {
+    salt := make([]byte, S2K_SALT_LENGTH)
+    if _, e := rand.Read(salt); e != nil {
+        return "", e
+    }
+    return S2K_PREFIX + strings.ToUpper(hex.EncodeToString(s2kSpecifier(password, salt, S2K_INDICATOR))), nil
+}
+
+// Returns true if password matches hashed, as produced by HashPassword().
+func VerifyHashedPassword(password []byte, hashed string) bool {
+    if !strings.HasPrefix(hashed, S2K_PREFIX) { return false }
+
+    data, e := hex.DecodeString(hashed[len(S2K_PREFIX):])
+    if e != nil || len(data) != S2K_SALT_LENGTH + 1 + sha1.Size { return false }
+
+    expected := s2kSpecifier(password, data[:S2K_SALT_LENGTH], data[S2K_SALT_LENGTH])
+    return hmac.Equal(data, expected)
+}
+
+// Computes salt | indicator | SHA1 of (salt | password) repeated up to the
+// number of bytes encoded by indicator.
+func s2kSpecifier(password, salt []byte, indicator byte) []byte {
+    count := (16 + int(indicator & 15)) << ((indicator >> 4) + 6)
+
+    data := append(append([]byte(nil), salt...), password...)
+    defer zero(data)
+
+    h := sha1.New()
+    for count > len(data) {
+        h.Write(data)
+        count -= len(data)
+    }
+    h.Write(data[:count])
+
+    return h.Sum(append(append([]byte(nil), salt...), indicator))
+}
+
+// Hashes password with HashPassword() and applies it as the
+// HashedControlPassword option with SetConf.
+func (c *Controller) SetHashedControlPassword(password []byte) (*SetConfResponse, error) {
+    return c.SetHashedControlPasswordContext(context.Background(), password)
+}
+
+// As SetHashedControlPassword(), using ctx to bound the request.
+func (c *Controller) SetHashedControlPasswordContext(ctx context.Context, password []byte) (*SetConfResponse, error) {
+    hashed, e := HashPassword(password)
+    if e != nil { return nil, e }
+
+    return c.SetConfContext(ctx, NewConfig("HashedControlPassword", hashed))
+}
+
 // A SafeCookieAuthenticator implements the Authenticator interface to provide
 // a more secure form of cookie based authentication, where the cookie data is
-// not transmitted in plain text.
+// not transmitted in plain text. Both sides prove knowledge of the cookie
+// through an AUTHCHALLENGE exchange, so a process impersonating the control
+// port never learns it. CookieFile overrides the cookie path reported by
+// PROTOCOLINFO when set.
 type SafeCookieAuthenticator struct {
     CookieFile string
 }
 
 func (a *SafeCookieAuthenticator) MethodName() string { return "SAFECOOKIE" }
 
-func (a *SafeCookieAuthenticator) Authenticate(c *Controller, protoinfo *ProtocolInfoResponse) error {
-    LogInfo("Attempting safe-cookie authentication...")
-    return NotImplemented()
+func (a *SafeCookieAuthenticator) Authenticate(ctx context.Context, c *Controller, protoinfo *ProtocolInfoResponse) error {
+    c.logger().Debug("Attempting safe-cookie authentication.")
+
+    path := a.CookieFile
+    if path == "" {
+        var ok bool
+        if path, ok = protoinfo.AuthCookieFile(); !ok {
+            return fmt.Errorf("No cookie file advertised")
+        }
+    }
+
+    cookie, e := ioutil.ReadFile(path)
+    if e != nil {
+        c.logger().Error("Failed to read cookie.", "error", e)
+        return e
+    }
+    if len(cookie) != AUTH_COOKIE_LENGTH {
+        return fmt.Errorf("Invalid cookie length: %d", len(cookie))
+    }
+
+    clientNonce := make([]byte, AUTH_COOKIE_LENGTH)
+    if _, e := rand.Read(clientNonce); e != nil {
+        return e
+    }
+
+    challenge, e := c.AuthChallengeContext(ctx, AUTH_CHALLENGE_SAFECOOKIE, clientNonce)
+    if e != nil {
+        c.logger().Warn("AUTHCHALLENGE request failed.", "error", e)
+        return e
+    }
+
+    serverHash, e := challenge.ServerHash()
+    if e != nil { return fmt.Errorf("Invalid SERVERHASH: %v", e) }
+    serverNonce, e := challenge.ServerNonce()
+    if e != nil { return fmt.Errorf("Invalid SERVERNONCE: %v", e) }
+
+    // Verify the server knows the cookie before revealing that we do.
+    expected := safeCookieHash(SAFECOOKIE_SERVER_KEY, cookie, clientNonce, serverNonce)
+    if !hmac.Equal(serverHash, expected) {
+        return ErrServerHashMismatch
+    }
+
+    clientHash := safeCookieHash(SAFECOOKIE_CLIENT_KEY, cookie, clientNonce, serverNonce)
+
+    request, e := NewRequestBuilder(COMMAND_AUTHENTICATE).Arg(hex.EncodeToString(clientHash)).Build()
+    if e != nil { return e }
+
+    _, e = Do[AuthResponse](ctx, c, request)
+    if e != nil {
+        c.logger().Warn("AUTHENTICATE request failed.", "error", e)
+        return e
+    }
+
+    return nil
+}
+
+// Computes HMAC-SHA256(key, cookie | clientNonce | serverNonce).
+func safeCookieHash(key string, cookie, clientNonce, serverNonce []byte) []byte {
+    h := hmac.New(sha256.New, []byte(key))
+    h.Write(cookie)
+    h.Write(clientNonce)
+    h.Write(serverNonce)
+    return h.Sum(nil)
 }
 
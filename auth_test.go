@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+)
+
+// Answers as fakeReply, but requires a password that's never accepted.
+func passwordReply(attempts *atomic.Int32) func(string) []string {
+    return func(line string) []string {
+        switch {
+        case strings.HasPrefix(line, "PROTOCOLINFO"):
+            return []string{"250-PROTOCOLINFO 1", "250-AUTH METHODS=HASHEDPASSWORD", "250-VERSION Tor=\"0.4.8.1\"", "250 OK"}
+        case strings.HasPrefix(line, "AUTHENTICATE"):
+            attempts.Add(1)
+            return []string{"515 Authentication failed: Password did not match HashedControlPassword value from configuration"}
+        }
+        return fakeReply(line)
+    }
+}
+
+func TestPasswordAuthenticationWithoutPassword(t *testing.T) {
+    var attempts atomic.Int32
+    c, _ := newFakeTor(t, passwordReply(&attempts))
+
+    e := c.Connect()
+    if !errors.Is(e, ErrNoPassword) { t.Fatalf("Expected ErrNoPassword, got %v", e) }
+    if attempts.Load() != 0 { t.Fatal("AUTHENTICATE sent without a password") }
+}
+
+func TestPasswordAuthenticationErrorOmitsRequest(t *testing.T) {
+    var attempts atomic.Int32
+    c, _ := newFakeTor(t, passwordReply(&attempts))
+    c.PasswordProvider = StaticPasswordProvider("hunter2")
+
+    e := c.Connect()
+    if !errors.Is(e, ErrBadAuthentication) { t.Fatalf("Expected ErrBadAuthentication, got %v", e) }
+
+    var reply *ReplyError
+    if !errors.As(e, &reply) { t.Fatalf("Expected *ReplyError, got %T", e) }
+    if reply.Request != nil { t.Fatal("Returned error holds the AUTHENTICATE request") }
+}
+
+// A net.Conn keeping a reference to each buffer written, rather than a copy.
+type retainingConn struct {
+    net.Conn
+
+    mutex  sync.Mutex
+    writes [][]byte
+}
+
+func (c *retainingConn) Write(b []byte) (int, error) {
+    c.mutex.Lock()
+    c.writes = append(c.writes, b)
+    c.mutex.Unlock()
+    return c.Conn.Write(b)
+}
+
+func TestPasswordAuthenticationZeroesSecrets(t *testing.T) {
+    encoded := hex.EncodeToString([]byte("hunter2"))
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "PROTOCOLINFO") {
+            return []string{"250-PROTOCOLINFO 1", "250-AUTH METHODS=HASHEDPASSWORD", "250-VERSION Tor=\"0.4.8.1\"", "250 OK"}
+        }
+        if strings.HasPrefix(line, "AUTHENTICATE") && line != "AUTHENTICATE " + encoded {
+            return []string{"515 Authentication failed"}
+        }
+        return fakeReply(line)
+    })
+
+    conn := &retainingConn{}
+    dialer := c.Dialer
+    c.Dialer = func(network, address string) (net.Conn, error) {
+        local, e := dialer(network, address)
+        conn.Conn = local
+        return conn, e
+    }
+
+    var logs bytes.Buffer
+    c.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+    password := []byte("hunter2")
+    c.PasswordProvider = PasswordProviderFunc(func(context.Context) ([]byte, error) { return password, nil })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    if !bytes.Equal(password, make([]byte, len(password))) { t.Errorf("Password not zeroed: %q", password) }
+
+    conn.mutex.Lock()
+    defer conn.mutex.Unlock()
+    authenticated := false
+    for _, v := range conn.writes {
+        if bytes.Contains(v, []byte("hunter2")) || bytes.Contains(v, []byte(encoded)) { t.Errorf("Written buffer not zeroed: %q", v) }
+        if bytes.HasPrefix(v, []byte(COMMAND_AUTHENTICATE)) { authenticated = true }
+    }
+    if authenticated { t.Error("AUTHENTICATE line left intact") }
+    if strings.Contains(logs.String(), encoded) { t.Error("Password logged") }
+}
+
+func TestS2KKnownAnswer(t *testing.T) {
+    // With an empty password and zero salt, 65536 bytes of zeroes are hashed.
+    // This is the check used by Tor's own test suite.
+    got := strings.ToUpper(hex.EncodeToString(s2kSpecifier(nil, make([]byte, S2K_SALT_LENGTH), S2K_INDICATOR)))
+    if want := "0000000000000000601ADC95BEBE9EEA8C112D40CD04AB7A8D75C4F961"; got != want {
+        t.Fatalf("Expected %s, got %s", want, got)
+    }
+
+    hashed := "16:8A0F6B0AD2B5B6F360245A091B3560BBD736A34679CF3F79DEB2BA5CAA"
+    if !VerifyHashedPassword([]byte("foo"), hashed) { t.Fatal("Known hash of \"foo\" not verified") }
+    if VerifyHashedPassword([]byte("fo"), hashed) { t.Fatal("Wrong password verified") }
+}
+
+func TestHashPassword(t *testing.T) {
+    hashed, e := HashPassword([]byte("foo"))
+    if e != nil { t.Fatal(e) }
+    if !strings.HasPrefix(hashed, S2K_PREFIX) || len(hashed) != len(S2K_PREFIX) + 2 * (S2K_SALT_LENGTH + 1 + 20) {
+        t.Fatalf("Malformed hash %s", hashed)
+    }
+    if !VerifyHashedPassword([]byte("foo"), hashed) { t.Fatal("Hash not verified") }
+}
+
+func TestSafeCookieHashes(t *testing.T) {
+    cookie, clientNonce, serverNonce := make([]byte, 32), make([]byte, 32), make([]byte, 32)
+    for i := 0; i < 32; i++ {
+        cookie[i], clientNonce[i], serverNonce[i] = byte(i), byte(32 + i), byte(64 + i)
+    }
+
+    server := hex.EncodeToString(safeCookieHash(SAFECOOKIE_SERVER_KEY, cookie, clientNonce, serverNonce))
+    if want := "3c8780ab52365c0d080750447e5f64dabc00428c6c434579c2043e18c1f85389"; server != want {
+        t.Errorf("Expected server hash %s, got %s", want, server)
+    }
+    client := hex.EncodeToString(safeCookieHash(SAFECOOKIE_CLIENT_KEY, cookie, clientNonce, serverNonce))
+    if want := "b47642df2d5abb84f69e6d02d41bed6b44aee33e69562528a82166fc98bc0b1e"; client != want {
+        t.Errorf("Expected client hash %s, got %s", want, client)
+    }
+}
+
+func TestSafeCookieAuthentication(t *testing.T) {
+    cookie := bytes.Repeat([]byte{0xA5}, 32)
+    path := filepath.Join(t.TempDir(), "control_auth_cookie")
+    if e := os.WriteFile(path, cookie, 0600); e != nil { t.Fatal(e) }
+
+    serverNonce := bytes.Repeat([]byte{0x5A}, 32)
+    var clientNonce []byte
+    var authenticated atomic.Bool
+
+    c, _ := newFakeTor(t, func(line string) []string {
+        switch {
+        case strings.HasPrefix(line, "PROTOCOLINFO"):
+            return []string{"250-PROTOCOLINFO 1", `250-AUTH METHODS=COOKIE,SAFECOOKIE COOKIEFILE="` + path + `"`, "250 OK"}
+        case strings.HasPrefix(line, "AUTHCHALLENGE SAFECOOKIE "):
+            clientNonce, _ = hex.DecodeString(strings.TrimPrefix(line, "AUTHCHALLENGE SAFECOOKIE "))
+            hash := safeCookieHash(SAFECOOKIE_SERVER_KEY, cookie, clientNonce, serverNonce)
+            return []string{fmt.Sprintf("250 AUTHCHALLENGE SERVERHASH=%X SERVERNONCE=%X", hash, serverNonce)}
+        case strings.HasPrefix(line, "AUTHENTICATE"):
+            hash := safeCookieHash(SAFECOOKIE_CLIENT_KEY, cookie, clientNonce, serverNonce)
+            if line != fmt.Sprintf("AUTHENTICATE %x", hash) { return []string{"515 Authentication failed"} }
+            authenticated.Store(true)
+            return []string{"250 OK"}
+        }
+        return fakeReply(line)
+    })
+
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+    if !authenticated.Load() { t.Fatal("Authenticated without SAFECOOKIE") }
+    if name := c.Authenticator().MethodName(); name != "SAFECOOKIE" { t.Fatalf("Authenticated with %s", name) }
+}
+
+func TestSafeCookieRejectsServerHash(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "control_auth_cookie")
+    if e := os.WriteFile(path, make([]byte, 32), 0600); e != nil { t.Fatal(e) }
+
+    var attempts atomic.Int32
+    c, _ := newFakeTor(t, func(line string) []string {
+        switch {
+        case strings.HasPrefix(line, "PROTOCOLINFO"):
+            return []string{"250-PROTOCOLINFO 1", `250-AUTH METHODS=SAFECOOKIE COOKIEFILE="` + path + `"`, "250 OK"}
+        case strings.HasPrefix(line, "AUTHCHALLENGE"):
+            return []string{fmt.Sprintf("250 AUTHCHALLENGE SERVERHASH=%X SERVERNONCE=%X", make([]byte, 32), make([]byte, 32))}
+        case strings.HasPrefix(line, "AUTHENTICATE"):
+            attempts.Add(1)
+        }
+        return fakeReply(line)
+    })
+
+    var failure *AuthError
+    if e := c.Connect(); !errors.As(e, &failure) { t.Fatalf("Expected *AuthError, got %v", e) }
+    if attempts.Load() != 0 { t.Fatal("AUTHENTICATE sent despite a bad server hash") }
+}
+
+func TestSafeCookieMismatchStopsFallback(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "control_auth_cookie")
+    if e := os.WriteFile(path, make([]byte, 32), 0600); e != nil { t.Fatal(e) }
+
+    var attempts atomic.Int32
+    c, _ := newFakeTor(t, func(line string) []string {
+        switch {
+        case strings.HasPrefix(line, "PROTOCOLINFO"):
+            return []string{"250-PROTOCOLINFO 1", `250-AUTH METHODS=COOKIE,SAFECOOKIE COOKIEFILE="` + path + `"`, "250 OK"}
+        case strings.HasPrefix(line, "AUTHCHALLENGE"):
+            return []string{fmt.Sprintf("250 AUTHCHALLENGE SERVERHASH=%X SERVERNONCE=%X", make([]byte, 32), make([]byte, 32))}
+        case strings.HasPrefix(line, "AUTHENTICATE"):
+            attempts.Add(1)
+            return []string{"250 OK"}
+        }
+        return fakeReply(line)
+    })
+
+    var failure *AuthError
+    e := c.Connect()
+    if !errors.As(e, &failure) || !errors.Is(e, ErrServerHashMismatch) { t.Fatalf("Expected ErrServerHashMismatch, got %v", e) }
+    if len(failure.Attempts) != 1 { t.Fatalf("Expected a single attempt, got %v", failure.Attempts) }
+    if attempts.Load() != 0 { t.Fatal("Cookie revealed after a bad server hash") }
+}
+
+func TestAuthFallbackReopensConnection(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "control_auth_cookie")
+    if e := os.WriteFile(path, make([]byte, 32), 0600); e != nil { t.Fatal(e) }
+
+    c, remotes := newFakeTor(t, func(line string) []string {
+        switch {
+        case strings.HasPrefix(line, "PROTOCOLINFO"):
+            return []string{"250-PROTOCOLINFO 1", `250-AUTH METHODS=COOKIE,HASHEDPASSWORD COOKIEFILE="` + path + `"`, "250 OK"}
+        case line == "AUTHENTICATE " + hex.EncodeToString([]byte("hunter2")):
+            return []string{"250 OK"}
+        case strings.HasPrefix(line, "AUTHENTICATE"):
+            return []string{"515 Authentication failed"}
+        }
+        return fakeReply(line)
+    })
+    c.PasswordProvider = StaticPasswordProvider("hunter2")
+
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+    if name := c.Authenticator().MethodName(); name != "HASHEDPASSWORD" { t.Fatalf("Authenticated with %s", name) }
+    if n := len(remotes); n != 2 { t.Fatalf("Expected the connection to be reopened once, got %d dials", n) }
+}
+
+func TestAuthErrorAggregatesAttempts(t *testing.T) {
+    var attempts atomic.Int32
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "PROTOCOLINFO") {
+            return []string{"250-PROTOCOLINFO 1", `250-AUTH METHODS=COOKIE,HASHEDPASSWORD COOKIEFILE="/nonexistent/cookie"`, "250 OK"}
+        }
+        return passwordReply(&attempts)(line)
+    })
+    c.PasswordProvider = StaticPasswordProvider("wrong")
+
+    var failure *AuthError
+    e := c.Connect()
+    if !errors.As(e, &failure) { t.Fatalf("Expected *AuthError, got %v", e) }
+
+    if strings.Join(failure.Advertised, ",") != "COOKIE,HASHEDPASSWORD" { t.Errorf("Unexpected advertised methods %v", failure.Advertised) }
+    if len(failure.Attempts) != 2 || failure.Attempts[0].Method != "COOKIE" || failure.Attempts[1].Method != "HASHEDPASSWORD" {
+        t.Fatalf("Unexpected attempts %v", failure.Attempts)
+    }
+    if !errors.Is(e, os.ErrNotExist) || !errors.Is(e, ErrBadAuthentication) { t.Fatalf("Attempt errors not unwrapped from %v", e) }
+}
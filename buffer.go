@@ -41,6 +41,39 @@ type ResponseBuffer struct {
     MidReplyLines  []MidReplyLine
     DataReplyLines []DataReplyLine
     EndReplyLine    EndReplyLine
+
+    // All received lines of the reply, in order of arrival.
+    RawLines       []string
+}
+
+// Returns true if the buffer holds an asynchronous (6yz) event reply.
+func (b ResponseBuffer) IsAsync() bool {
+    status := b.EndReplyLine.Status()
+    return status > 599 && status < 700
+}
+
+// Returns the keyword of an asynchronous event reply, e.g. "CIRC". The keyword
+// is taken from the first line of the reply.
+func (b ResponseBuffer) EventName() string {
+    return strings.SplitN(b.firstLineText(), " ", 2)[0]
+}
+
+// Returns the text of the first reply line, without the status prefix.
+func (b ResponseBuffer) firstLineText() string {
+    if len(b.RawLines) > 0 && len(b.RawLines[0]) > 3 {
+        return b.RawLines[0][4:]
+    }
+
+    text := ""
+    switch {
+    case len(b.MidReplyLines) > 0:
+        text = b.MidReplyLines[0].Text()
+    case len(b.DataReplyLines) > 0:
+        text = b.DataReplyLines[0].Text()
+    default:
+        text = b.EndReplyLine.StatusText()
+    }
+    return text
 }
 
 // Returns the status integer in a MidReplyLine.
@@ -56,7 +89,7 @@ func (l MidReplyLine) Text() string {
 }
 
 func (l MidReplyLine) _parts() []string {
-    return strings.SplitN(string(l), "-", 2)
+    return __split_status(string(l), "-")
 }
 
 // Returns the status integer in a DataReplyLine
@@ -72,7 +105,8 @@ func (l DataReplyLine) Text() string {
 }
 
 func (l DataReplyLine) _parts() []string {
-    return strings.SplitN(string(l[0]), "+", 2)
+    if len(l) == 0 { return []string{"", ""} }
+    return __split_status(l[0], "+")
 }
 
 // Returns Status code of a response.
@@ -88,7 +122,32 @@ func (l EndReplyLine) StatusText() string {
 }
 
 func (l EndReplyLine) _status_parts() []string {
-    return strings.SplitN(string(l), " ", 2)
+    return __split_status(string(l), " ")
+}
+
+// Splits a reply line into its status and text, the text being empty for
+// truncated lines.
+func __split_status(ln string, sep string) []string {
+    parts := strings.SplitN(ln, sep, 2)
+    if len(parts) < 2 { parts = append(parts, "") }
+    return parts
+}
+
+// Splits body into lines for transmission as the data of a multi-line request,
+// terminated by a line containing a single ".". Lines starting with "." are
+// escaped with an additional "." so they can't terminate the data early.
+func EscapeData(body string) LineBuffer {
+    body = strings.Replace(body, "\r\n", "\n", -1)
+    body = strings.TrimSuffix(body, "\n")
+
+    results := make(LineBuffer, 0)
+    if body != "" {
+        for _, v := range strings.Split(body, "\n") {
+            if strings.HasPrefix(v, ".") { v = "." + v }
+            results = append(results, v)
+        }
+    }
+    return append(results, ".")
 }
 
 // Makes sure that the message is formatted correctly, returns []byte for
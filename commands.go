@@ -24,14 +24,15 @@
 package torc
 
 import (
-    "regexp"
+    "context"
+    "encoding/hex"
     "strconv"
     "strings"
 )
 
 // Tor control protocol command constants, use these when building commands from
-// scratch using NewRequest. Currently only a subset of these commands are
-// implemented in the API, hopefully more will be added as torc matures..
+// scratch using NewRequestBuilder. Currently only a subset of these commands
+// are implemented in the API, hopefully more will be added as torc matures..
 const (
               COMMAND_SETCONF = "SETCONF"
             COMMAND_RESETCONF = "RESETCONF"
@@ -65,48 +66,45 @@ const (
 ) // TODO: Wrap in a ControlCommand semantic type.
 
 // The GetInfoResponse type is returned by the GetInfo command method.
-type GetInfoResponse struct { *BaseControlResponse }
-
-// Return first MidReply value, or first DataReply value
-func (m *GetInfoResponse) Value() string {
-    // Try to get value from MidReplyLine and return.
-    if len(m.Buffer.MidReplyLines) == 1 {
-        return strings.SplitN(string(m.Buffer.MidReplyLines[0]), "=", 2)[1]
-    }
-    // Otherwise get value from DataReply lines and return.
-    if len(m.Buffer.DataReplyLines) == 1 {
-        data := m.Buffer.DataReplyLines[0]
-        return strings.Join([]string(data)[1:], "\n")
-    }
-
-    //FIXME: Do we really want this function to return empty if there are more
-    // than one mid or data reply lines? Maybe we should just return the first
-    // one.
-    return ""
+type GetInfoResponse struct { BaseControlResponse }
+
+// Returns the value of a single key request, from its MidReply or DataReply
+// line. False if the response doesn't hold exactly one value.
+func (m *GetInfoResponse) Value() (string, bool) {
+    values := m.values()
+    if len(values) != 1 { return "", false }
+    return values[0].Value, true
 }
 
-// Return specific mid or data reply value relating to key.
-func (m *GetInfoResponse) ValueOf(key string) string {
-    // Try to get value from a MidReplyLine and return it.
-    text := __find_prefix_mrl(m.Buffer.MidReplyLines, key)
-    if text != "" { return strings.TrimPrefix(text, key + "=") }
-    // Otherwise get value from a DataReply and return it (or empty string)
-    return __find_prefix_drl(m.Buffer.DataReplyLines, key)
+// Returns the value relating to key, false if it wasn't returned.
+func (m *GetInfoResponse) ValueOf(key string) (string, bool) {
+    for _, v := range m.values() {
+        if v.Key == key { return v.Value, true }
+    }
+    return "", false
 }
 
 // Return all received values as a map.
 func (m *GetInfoResponse) ValueAll() map[string]string {
     results := make(map[string]string)
-    // Iterate over mid reply lines and add to dictionary.
+    for _, v := range m.values() {
+        results[v.Key] = v.Value
+    }
+    return results
+}
+
+// Collects "key=value" MidReply lines and "key=" DataReply lines, in order.
+func (m *GetInfoResponse) values() ReplyArgs {
+    results := make(ReplyArgs, 0)
     for _, v := range m.Buffer.MidReplyLines {
-        parts := strings.SplitN(v.Text(), "=", 2)
-        results[parts[0]] = parts[1]
+        key, value, ok := ParseKeyValue(v.Text())
+        if !ok { continue }
+        results = append(results, ReplyArg{Key: key, Value: value})
     }
-    // Iterate over data reply lines and add data to dictionary.
     for _, v := range m.Buffer.DataReplyLines {
-        k := strings.TrimSuffix(v.Text(), "=")
-        v := strings.Join(v[1:], "\n")
-        results[k] = v
+        key, _, ok := ParseKeyValue(v.Text())
+        if !ok { continue }
+        results = append(results, ReplyArg{Key: key, Value: strings.Join(v[1:], "\n")})
     }
     return results
 }
@@ -114,157 +112,218 @@ func (m *GetInfoResponse) ValueAll() map[string]string {
 // Perform GETINFO command request. Returns GetInfoResponse instance reflecting
 // command result.
 func (c *Controller) GetInfo(keys []string) (*GetInfoResponse, error) {
-    request  := NewRequest(COMMAND_GETINFO + " " + strings.Join(keys, " "))
-    response := &GetInfoResponse{}
-    return response, c.Request(request, response)
+    return c.GetInfoContext(context.Background(), keys)
 }
 
-// The ProtocolInfoResponse type is returned by the ProtocolInfo command method.
-type ProtocolInfoResponse struct { *BaseControlResponse }
+// Perform GETINFO command request, as with GetInfo(), using ctx to bound
+// the request.
+func (c *Controller) GetInfoContext(ctx context.Context, keys []string) (*GetInfoResponse, error) {
+    request, e := NewRequestBuilder(COMMAND_GETINFO).Args(keys...).Build()
+    if e != nil { return nil, e }
+    return Do[GetInfoResponse](ctx, c, request)
+}
 
-// Get protocol version number integer from response.
-func (m *ProtocolInfoResponse) Protocol() int {
-    text := __find_prefix_mrl(m.Buffer.MidReplyLines, "PROTOCOLINFO")
-    i, e := strconv.Atoi(strings.SplitN(text, " ", 2)[1])
-    if e != nil { return -1 }
-    return i
+// The ProtocolInfoResponse type is returned by the ProtocolInfo command method.
+type ProtocolInfoResponse struct { BaseControlResponse }
+
+// Get protocol version number integer from response, false if it's missing or
+// malformed.
+func (m *ProtocolInfoResponse) Protocol() (int, bool) {
+    v, ok := m.line("PROTOCOLINFO").Arg(0)
+    if !ok { return -1, false }
+    i, e := strconv.Atoi(v)
+    if e != nil { return -1, false }
+    return i, true
 }
 
 // Get protocol auth line key value pairs in a map.
 func (m *ProtocolInfoResponse) Auth() map[string]string {
-    text := __find_prefix_mrl(m.Buffer.MidReplyLines, "AUTH")
-    return __make_variable_map(text[len("AUTH"):])
+    return m.line("AUTH").Map()
 }
 
 // Get auth methods from protocol auth line as strings.
 func (m *ProtocolInfoResponse) AuthMethods() []string {
-    return strings.SplitN(m.Auth()["METHODS"], ",", -1)
+    v, ok := m.line("AUTH").Value("METHODS")
+    if !ok || v == "" { return []string{} }
+    return strings.Split(v, ",")
 }
 
-// Get auth cookie path as string.
-func (m *ProtocolInfoResponse) AuthCookieFile() string {
-    return m.Auth()["COOKIEFILE"]
+// Get auth cookie path as string, false if Tor didn't report one.
+func (m *ProtocolInfoResponse) AuthCookieFile() (string, bool) {
+    return m.line("AUTH").Value("COOKIEFILE")
 }
 
 // Get software version key value pairs in a map.
 func (m *ProtocolInfoResponse) Version() map[string]string {
-    text := __find_prefix_mrl(m.Buffer.MidReplyLines, "VERSION")
-    return __make_variable_map(text[len("VERSION"):])
+    return m.line("VERSION").Map()
+}
+
+// Tokenizes the arguments of the MidReply line starting with keyword.
+func (m *ProtocolInfoResponse) line(keyword string) ReplyArgs {
+    for _, v := range m.Buffer.MidReplyLines {
+        text := v.Text()
+        if text == keyword || strings.HasPrefix(text, keyword + " ") {
+            return ParseReplyArgs(text[len(keyword):])
+        }
+    }
+    return ReplyArgs{}
 }
 
 // Perform PROTOCOLINFO command request. Returns ProtocolInfoResponse instance
 // reflecting command result.
 func (c *Controller) ProtocolInfo() (*ProtocolInfoResponse, error) {
+    return c.ProtocolInfoContext(context.Background())
+}
+
+// Perform PROTOCOLINFO command request, as with ProtocolInfo(), using ctx to
+// bound the request.
+func (c *Controller) ProtocolInfoContext(ctx context.Context) (*ProtocolInfoResponse, error) {
     request := NewRequest(COMMAND_PROTOCOLINFO)
-    response := &ProtocolInfoResponse{}
-    return response, c.Request(request, response)
+    return Do[ProtocolInfoResponse](ctx, c, request)
 }
 
 // The GetConfResponse type is returned by the GetConf command method.
-type GetConfResponse struct { *BaseControlResponse }
+type GetConfResponse struct { BaseControlResponse }
 
 // Get singleton value from response and return it (short hand for single reqs.)
-func (m *GetConfResponse) Value() string {
-    //FIXME: Maybe just return first value?
-    return strings.SplitN(m.Buffer.EndReplyLine.StatusText(), "=", 2)[1]
+// False if the option is unset, or more than one value was returned.
+func (m *GetConfResponse) Value() (string, bool) {
+    config := m.ValueAll()
+    if len(config) != 1 || config[0].IsDefault { return "", false }
+    return config[0].Value, true
 }
 
-// Get specific value from configuration ``key'' from request.
-func (m *GetConfResponse) ValueOf(key string) string {
-    for _, i := range m.Buffer.MidReplyLines {
-        parts := strings.SplitN(i.Text(), "=", 2)
-        if parts[0] == key { return parts[1] }
-    }
+// Get the first value of configuration ``key'' from request, false if it's
+// unset.
+func (m *GetConfResponse) ValueOf(key string) (string, bool) {
+    return m.ValueAll().Get(key)
+}
 
-    return ""
+// Get every value of configuration ``key'' from request, for options that may
+// be given more than once such as SocksPort.
+func (m *GetConfResponse) ValuesOf(key string) []string {
+    return m.ValueAll().GetAll(key)
 }
 
-// Get all values from configuration as a map.
-func (m *GetConfResponse) ValueAll() map[string]string {
-    results := make(map[string]string)
-    // Collect results from MidReplyLines
-    for _, i := range m.Buffer.MidReplyLines {
-        parts := strings.SplitN(i.Text(), "=", 2)
-        results[parts[0]] = parts[1]
+// Get all values from configuration in the order returned. Options may appear
+// more than once, unset options are returned with IsDefault set.
+func (m *GetConfResponse) ValueAll() Config {
+    results := make(Config, 0)
+    if !m.IsSuccess() { return results }
+
+    for _, v := range m.Buffer.MidReplyLines {
+        results = append(results, parseConfigEntry(v.Text()))
     }
-    // Append result from EndReplyLine
-    parts := strings.SplitN(m.Buffer.EndReplyLine.StatusText(), "=", 2)
-    results[parts[0]] = parts[1]
-    return results
+    return append(results, parseConfigEntry(m.Buffer.EndReplyLine.StatusText()))
 }
 
 // Perform GETCONF command request. Returns GetConfResponse instance reflecting
 // command result.
 func (c *Controller) GetConf(keys []string) (*GetConfResponse, error) {
-    request := NewRequest(COMMAND_GETCONF + " " + strings.Join(keys, " "))
-    response := &GetConfResponse{}
-    return response, c.Request(request, response)
+    return c.GetConfContext(context.Background(), keys)
 }
 
-// The SetConfResponse type is returned by the SetConf command method.
-type SetConfResponse struct { *BaseControlResponse }
+// Perform GETCONF command request, as with GetConf(), using ctx to bound
+// the request.
+func (c *Controller) GetConfContext(ctx context.Context, keys []string) (*GetConfResponse, error) {
+    builder := NewRequestBuilder(COMMAND_GETCONF)
+    for _, k := range keys { builder.Key(k) }
+    request, e := builder.Build()
+    if e != nil { return nil, e }
+    return Do[GetConfResponse](ctx, c, request)
+}
 
-// Perform SETCONF command request. Returns SetConfResponse instance reflecting
-// command result.
-func (c *Controller) SetConf(opts map[string][]string) (*SetConfResponse, error) {
-    kvs := make([]string, 0)
-    for k, v := range opts {
-        for _, j := range v {
-            kvs = append(kvs, k + "=" + j)
-        }
-    }
-    request := NewRequest(COMMAND_SETCONF + " " + strings.Join(kvs, " "))
-    response := &SetConfResponse{}
-    return response, c.Request(request, response)
+// The SetConfResponse type is returned by the SetConf command method.
+type SetConfResponse struct { BaseControlResponse }
+
+// Perform SETCONF command request, applying config in order. Repeated keys set
+// multiple values, and entries with IsDefault set are sent without a value,
+// which clears the option. Returns SetConfResponse instance reflecting command
+// result.
+func (c *Controller) SetConf(config Config) (*SetConfResponse, error) {
+    return c.SetConfContext(context.Background(), config)
 }
 
+// Perform SETCONF command request, as with SetConf(), using ctx to bound
+// the request.
+func (c *Controller) SetConfContext(ctx context.Context, config Config) (*SetConfResponse, error) {
+    request, e := config.encode(NewRequestBuilder(COMMAND_SETCONF)).Build()
+    if e != nil { return nil, e }
+    return Do[SetConfResponse](ctx, c, request)
+}
 
 // The ResetConfResponse type is returned by the ResetConf command method.
-type ResetConfResponse struct { *BaseControlResponse }
+type ResetConfResponse struct { BaseControlResponse }
 
-// Perform RESETCONF command request. Returns ResetConfResponse instance
-// reflecting command result.
-func (c *Controller) ResetConf(opts map[string][]string) (*ResetConfResponse, error) {
-    kvs := make([]string, 0)
-    for k, v := range opts {
-        for _, j := range v {
-            kvs = append(kvs, k + "=" + j)
-        }
-    }
-    request := NewRequest(COMMAND_RESETCONF + " " + strings.Join(kvs, " "))
-    response := &ResetConfResponse{}
-    return response, c.Request(request, response)
+// Perform RESETCONF command request, as SetConf() except that options without a
+// value are reset to their default rather than cleared. Returns
+// ResetConfResponse instance reflecting command result.
+func (c *Controller) ResetConf(config Config) (*ResetConfResponse, error) {
+    return c.ResetConfContext(context.Background(), config)
 }
 
-/*TODO: Implement multiline request support ....
-type LoadConfResponse struct { *BaseControlResponse }
-func (c *Controller) LoadConf() (*LoadConfResponse, error) {
-    request := NewRequest(COMMAND_LOADCONF)
-    response := &LoadConfResponse{}
-    return response, c.Request(request, response)
+// Perform RESETCONF command request, as with ResetConf(), using ctx to bound
+// the request.
+func (c *Controller) ResetConfContext(ctx context.Context, config Config) (*ResetConfResponse, error) {
+    request, e := config.encode(NewRequestBuilder(COMMAND_RESETCONF)).Build()
+    if e != nil { return nil, e }
+    return Do[ResetConfResponse](ctx, c, request)
+}
+
+// The LoadConfResponse type is returned by the LoadConf command method.
+type LoadConfResponse struct { BaseControlResponse }
+
+// Perform LOADCONF command request, replacing the running configuration with
+// the torrc formatted text. Returns LoadConfResponse instance reflecting
+// command result.
+func (c *Controller) LoadConf(text string) (*LoadConfResponse, error) {
+    return c.LoadConfContext(context.Background(), text)
+}
+
+// Perform LOADCONF command request, as with LoadConf(), using ctx to bound
+// the request.
+func (c *Controller) LoadConfContext(ctx context.Context, text string) (*LoadConfResponse, error) {
+    request := NewMultilineRequest(COMMAND_LOADCONF, text)
+    return Do[LoadConfResponse](ctx, c, request)
 }
-*/
 
 // The SaveConfResponse type is returned by the SaveConf command method.
-type SaveConfResponse struct { *BaseControlResponse }
+type SaveConfResponse struct { BaseControlResponse }
 
 // Perform SAVECONF command request. Returns ResetConfResponse instance
 // reflecting command result.
 func (c *Controller) SaveConf() (*SaveConfResponse, error) {
+    return c.SaveConfContext(context.Background())
+}
+
+// Perform SAVECONF command request, as with SaveConf(), using ctx to bound
+// the request.
+func (c *Controller) SaveConfContext(ctx context.Context) (*SaveConfResponse, error) {
     request := NewRequest(COMMAND_SAVECONF)
-    response := &SaveConfResponse{}
-    return response, c.Request(request, response)
+    return Do[SaveConfResponse](ctx, c, request)
 }
 
 // The SetEventsResponse type is returned by the SetEvents command method.
-type SetEventsResponse struct { *BaseControlResponse }
+type SetEventsResponse struct { BaseControlResponse }
 
 // Perform SETEVENTS command request. Returns SetEventsResponse instance
-// reflecting command result.
+// reflecting command result. Subscribed events are delivered to handlers
+// registered with AddEventHandler. The request replaces the whole set of
+// subscribed events, use Subscribe() when several components need events.
 func (c *Controller) SetEvents(events []string) (*SetEventsResponse, error) {
-    request := NewRequest(COMMAND_SETEVENTS + " " + strings.Join(events, " "))
-    response := &SetEventsResponse{}
-    return response, c.Request(request, response)
+    return c.SetEventsContext(context.Background(), events)
+}
+
+// Perform SETEVENTS command request, as with SetEvents(), using ctx to bound
+// the request.
+func (c *Controller) SetEventsContext(ctx context.Context, events []string) (*SetEventsResponse, error) {
+    request, e := NewRequestBuilder(COMMAND_SETEVENTS).Args(events...).Build()
+    if e != nil { return nil, e }
+    response, e := Do[SetEventsResponse](ctx, c, request)
+    if e == nil {
+        c.recordEvents(events)
+    }
+    return response, e
 }
 
 type Signal string
@@ -287,40 +346,175 @@ const (
 )
 
 // The SignalResponse type is returned by the Signal command method.
-type SignalResponse struct { *BaseControlResponse }
+type SignalResponse struct { BaseControlResponse }
 
 // Perform SIGNAL command request. Returns SignalResponse instance reflecting
 // command result.
 func (c *Controller) Signal(signal Signal) (*SignalResponse, error) {
-    request := NewRequest(COMMAND_SIGNAL + " " + string(signal))
-    response := &SignalResponse{}
-    return response, c.Request(request, response)
+    return c.SignalContext(context.Background(), signal)
+}
+
+// Perform SIGNAL command request, as with Signal(), using ctx to bound
+// the request.
+func (c *Controller) SignalContext(ctx context.Context, signal Signal) (*SignalResponse, error) {
+    request, e := NewRequestBuilder(COMMAND_SIGNAL).Arg(string(signal)).Build()
+    if e != nil { return nil, e }
+    return Do[SignalResponse](ctx, c, request)
 }
 
 // The DropGuardsResponse type is returned by the DropGuards command method.
-type DropGuardsResponse struct { *BaseControlResponse }
+type DropGuardsResponse struct { BaseControlResponse }
 
 // Perform DROPGUARDS command request. Returns DropGuardsResponse instance
 // reflecting command result.
 func (c *Controller) DropGuards() (*DropGuardsResponse, error) {
+    return c.DropGuardsContext(context.Background())
+}
+
+// Perform DROPGUARDS command request, as with DropGuards(), using ctx to bound
+// the request.
+func (c *Controller) DropGuardsContext(ctx context.Context) (*DropGuardsResponse, error) {
     request := NewRequest(COMMAND_DROPGUARDS)
-    response := &DropGuardsResponse{}
-    return response, c.Request(request, response)
+    return Do[DropGuardsResponse](ctx, c, request)
+}
+
+// The PostDescriptorResponse type is returned by the PostDescriptor command
+// method.
+type PostDescriptorResponse struct { BaseControlResponse }
+
+// Constants to use with the PostDescriptor command method.
+const (
+    POSTDESCRIPTOR_CACHE_YES = "yes"
+     POSTDESCRIPTOR_CACHE_NO = "no"
+)
+
+// Perform POSTDESCRIPTOR command request, uploading a server descriptor. The
+// optional purpose and cache arguments are omitted when empty. Returns
+// PostDescriptorResponse instance reflecting command result.
+func (c *Controller) PostDescriptor(purpose string,
+                                    cache string,
+                                    descriptor string) (*PostDescriptorResponse, error) {
+    return c.PostDescriptorContext(context.Background(), purpose, cache, descriptor)
+}
+
+// Perform POSTDESCRIPTOR command request, as with PostDescriptor(), using ctx
+// to bound the request.
+func (c *Controller) PostDescriptorContext(ctx context.Context,
+                                           purpose string,
+                                           cache string,
+                                           descriptor string) (*PostDescriptorResponse, error) {
+    builder := NewRequestBuilder(COMMAND_POSTDESCRIPTOR)
+
+    if purpose != "" {
+        builder.KeyValue("purpose", purpose)
+    }
+
+    if cache != "" {
+        builder.KeyValue("cache", cache)
+    }
+
+    request, e := builder.BuildMultiline(descriptor)
+    if e != nil { return nil, e }
+    return Do[PostDescriptorResponse](ctx, c, request)
+}
+
+// The HSPostResponse type is returned by the HSPost command method.
+type HSPostResponse struct { BaseControlResponse }
+
+// Perform HSPOST command request, uploading a hidden service descriptor to the
+// given HSDir servers, or the responsible ones when none are given. The
+// optional hsAddress is required for v3 descriptors. Returns HSPostResponse
+// instance reflecting command result.
+func (c *Controller) HSPost(servers []string,
+                            hsAddress string,
+                            descriptor string) (*HSPostResponse, error) {
+    return c.HSPostContext(context.Background(), servers, hsAddress, descriptor)
+}
+
+// Perform HSPOST command request, as with HSPost(), using ctx to bound the
+// request.
+func (c *Controller) HSPostContext(ctx context.Context,
+                                   servers []string,
+                                   hsAddress string,
+                                   descriptor string) (*HSPostResponse, error) {
+    builder := NewRequestBuilder(COMMAND_HSPOST)
+
+    for _, v := range servers {
+        builder.KeyValue("SERVER", v)
+    }
+
+    if hsAddress != "" {
+        builder.KeyValue("HSADDRESS", strings.TrimSuffix(hsAddress, ".onion"))
+    }
+
+    request, e := builder.BuildMultiline(descriptor)
+    if e != nil { return nil, e }
+    return Do[HSPostResponse](ctx, c, request)
+}
+
+// The AuthChallengeResponse type is returned by the AuthChallenge command
+// method.
+type AuthChallengeResponse struct { BaseControlResponse }
+
+// Returns the decoded SERVERHASH field of the challenge.
+func (m *AuthChallengeResponse) ServerHash() ([]byte, error) {
+    return hex.DecodeString(m.challenge()["SERVERHASH"])
+}
+
+// Returns the decoded SERVERNONCE field of the challenge.
+func (m *AuthChallengeResponse) ServerNonce() ([]byte, error) {
+    return hex.DecodeString(m.challenge()["SERVERNONCE"])
+}
+
+func (m *AuthChallengeResponse) challenge() map[string]string {
+    text := strings.TrimPrefix(m.StatusText(), COMMAND_AUTHCHALLENGE)
+    return ParseReplyArgs(text).Map()
+}
+
+// Constants to use with the AuthChallenge command method.
+const (
+    AUTH_CHALLENGE_SAFECOOKIE = "SAFECOOKIE"
+)
+
+// Perform AUTHCHALLENGE command request. Returns AuthChallengeResponse instance
+// reflecting command result.
+func (c *Controller) AuthChallenge(method string, clientNonce []byte) (*AuthChallengeResponse, error) {
+    return c.AuthChallengeContext(context.Background(), method, clientNonce)
+}
+
+// Perform AUTHCHALLENGE command request, as with AuthChallenge(), using ctx to
+// bound the request.
+func (c *Controller) AuthChallengeContext(ctx context.Context, method string, clientNonce []byte) (*AuthChallengeResponse, error) {
+    request, e := NewRequestBuilder(COMMAND_AUTHCHALLENGE).
+        Arg(method).
+        Arg(hex.EncodeToString(clientNonce)).
+        Build()
+    if e != nil { return nil, e }
+    return Do[AuthChallengeResponse](ctx, c, request)
 }
 
 // The AddOnionResponse type is returned by the AddOnion command method.
-type AddOnionResponse struct { *BaseControlResponse }
+type AddOnionResponse struct { BaseControlResponse }
 
 // Returns the ServiceID field of the created hidden service.
-func (m *AddOnionResponse) ServiceId() string {
-    v := __find_prefix_mrl(m.Buffer.MidReplyLines, "ServiceID=")
-    return v[len("ServiceID"):]
+func (m *AddOnionResponse) ServiceId() (string, bool) {
+    return m.field("ServiceID")
 }
 
-// Returns the PrivateKey field of the created hidden service.
-func (m *AddOnionResponse) PrivateKey() string {
-    v := __find_prefix_mrl(m.Buffer.MidReplyLines, "PrivateKey=")
-    return v[len("PrivateKey"):]
+// Returns the PrivateKey field of the created hidden service, false unless a
+// new key was generated without the DiscardPK flag.
+func (m *AddOnionResponse) PrivateKey() (Secret, bool) {
+    v, ok := m.field("PrivateKey")
+    return Secret(v), ok
+}
+
+func (m *AddOnionResponse) field(key string) (string, bool) {
+    for _, v := range m.Buffer.MidReplyLines {
+        if k, value, ok := ParseKeyValue(v.Text()); ok && k == key {
+            return value, true
+        }
+    }
+    return "", false
 }
 
 // Constants to use with the AddOnion command method.
@@ -338,64 +532,66 @@ const (
 // Perform ADD_ONION command request. Returns AddOnionResponse instance
 // reflecting command result.
 func (c *Controller) AddOnion(keyType string,
-                              keyData string,
+                              keyData Secret,
                               flags []string,
                               ports []string) (*AddOnionResponse, error) {
-    reqline := COMMAND_ADD_ONION + " " + string(keyType) + ":" + keyData
+    return c.AddOnionContext(context.Background(), keyType, keyData, flags, ports)
+}
+
+// Perform ADD_ONION command request, as with AddOnion(), using ctx to bound
+// the request.
+func (c *Controller) AddOnionContext(ctx context.Context,
+                                     keyType string,
+                                     keyData Secret,
+                                     flags []string,
+                                     ports []string) (*AddOnionResponse, error) {
+    builder := NewRequestBuilder(COMMAND_ADD_ONION).Arg(keyType + ":" + keyData.Reveal())
 
     if len(flags) > 0 {
-        reqline += " Flags=" + strings.Join(flags, ",")
+        builder.KeyValue("Flags", strings.Join(flags, ","))
     }
 
     for _, v := range ports {
-        reqline += " Port=" + v
+        builder.KeyValue("Port", v)
     }
 
-    request := NewRequest(reqline)
-    response := &AddOnionResponse{}
-    return response, c.Request(request, response)
+    request, e := builder.Build()
+    if e != nil { return nil, e }
+    response, e := Do[AddOnionResponse](ctx, c, request)
+    if e == nil {
+        c.recordOnion(response, keyType, keyData, flags, ports)
+    }
+    return response, e
 }
 
 // The DelOnionResponse type is returned by the DelOnion command method.
-type DelOnionResponse struct { *BaseControlResponse }
+type DelOnionResponse struct { BaseControlResponse }
 
 // Perform DEL_ONION command request. Returns DelOnionResponse instance
 // reflecting command result.
 func (c *Controller) DelOnion(serviceId string) (*DelOnionResponse, error) {
+    return c.DelOnionContext(context.Background(), serviceId)
+}
+
+// Perform DEL_ONION command request, as with DelOnion(), using ctx to bound
+// the request.
+func (c *Controller) DelOnionContext(ctx context.Context, serviceId string) (*DelOnionResponse, error) {
     if strings.HasSuffix(serviceId, ".onion") {
         serviceId = strings.TrimSuffix(serviceId, ".onion")
     }
-    request := NewRequest(COMMAND_DEL_ONION + " " + serviceId)
-    response := &DelOnionResponse{}
-    return response, c.Request(request, response)
-}
-
-// Helpers ---------------------------------------------------------------------
-// TODO: Move into parser, or buffer.go
-func __find_prefix_mrl(data []MidReplyLine, prefix string) string {
-    for _, v := range data {
-        if strings.HasPrefix(v.Text(), prefix) { return v.Text() }
+    request, e := NewRequestBuilder(COMMAND_DEL_ONION).Arg(serviceId).Build()
+    if e != nil { return nil, e }
+    response, e := Do[DelOnionResponse](ctx, c, request)
+    if e == nil {
+        c.forgetOnion(serviceId)
     }
-    return ""
+    return response, e
 }
 
-func __find_prefix_drl(data []DataReplyLine, prefix string) string {
+// Helpers ---------------------------------------------------------------------
+func __contains(data []string, value string) bool {
     for _, v := range data {
-        if strings.HasPrefix(v.Text(), prefix) {
-            return strings.Join(v[1:], "\n")
-        }
-    }
-    return ""
-}
-
-func __make_variable_map(data string) map[string]string {
-    results := make(map[string]string)
-    r := regexp.MustCompile(`([/\w]+)=(([,\w]+)|("(?:\\.|[^"\\])*)")`)
-
-    for _, j := range r.FindAllStringSubmatch(data, -1) {
-        results[j[1]] = strings.TrimPrefix(strings.TrimSuffix(j[2], "\""), "\"")
+        if v == value { return true }
     }
-
-    return results
+    return false
 }
-
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+// The ConfigEntry type is a single value of a configuration option. IsDefault
+// marks an option without a value, as reported by GETCONF for options at their
+// default and sent by ResetConf to restore the default.
+type ConfigEntry struct {
+    Key       string
+    Value     string
+    IsDefault bool
+}
+
+// Returns the entry as it's written in a SETCONF, RESETCONF or GETCONF line.
+func (e ConfigEntry) String() string {
+    if e.IsDefault { return e.Key }
+    return e.Key + "=" + e.Value
+}
+
+// The Config type holds an ordered list of configuration entries. Options may
+// be repeated, such as SocksPort, and order is kept for groups such as
+// HiddenServiceDir followed by its HiddenServicePort lines.
+type Config []ConfigEntry
+
+// Instantiates a Config holding a value for each key, value pair in kvs, e.g.
+// NewConfig("SocksPort", "9050", "SocksPort", "9150").
+func NewConfig(kvs ...string) Config {
+    c := make(Config, 0, len(kvs) / 2)
+    for i := 0; i + 1 < len(kvs); i += 2 {
+        c = c.Add(kvs[i], kvs[i+1])
+    }
+    return c
+}
+
+// Returns c with a value for key appended.
+func (c Config) Add(key, value string) Config {
+    return append(c, ConfigEntry{Key: key, Value: value})
+}
+
+// Returns c with key appended without a value, resetting it to its default.
+func (c Config) AddDefault(key string) Config {
+    return append(c, ConfigEntry{Key: key, IsDefault: true})
+}
+
+// Returns the first value of key, false if it's absent or unset.
+func (c Config) Get(key string) (string, bool) {
+    for _, v := range c {
+        if v.Key == key && !v.IsDefault { return v.Value, true }
+    }
+    return "", false
+}
+
+// Returns every value of key, in order.
+func (c Config) GetAll(key string) []string {
+    results := make([]string, 0)
+    for _, v := range c {
+        if v.Key == key && !v.IsDefault { results = append(results, v.Value) }
+    }
+    return results
+}
+
+// Returns the distinct keys, in order of first appearance.
+func (c Config) Keys() []string {
+    results := make([]string, 0)
+    for _, v := range c {
+        if !__contains(results, v.Key) { results = append(results, v.Key) }
+    }
+    return results
+}
+
+// Parses a "Key=Value", or bare "Key", configuration line.
+func parseConfigEntry(text string) ConfigEntry {
+    key, value, ok := ParseKeyValue(text)
+    return ConfigEntry{Key: key, Value: value, IsDefault: !ok}
+}
+
+// Appends an argument for each entry of c to builder.
+func (c Config) encode(builder *RequestBuilder) *RequestBuilder {
+    for _, v := range c {
+        if v.IsDefault {
+            builder.Key(v.Key)
+        } else {
+            builder.KeyValue(v.Key, v.Value)
+        }
+    }
+    return builder
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+
+package torc
+
+import (
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestConfig(t *testing.T) {
+    c := NewConfig("SocksPort", "9050", "ExitPolicy", "reject *:*", "SocksPort", "9150").AddDefault("ContactInfo").AddDefault("SocksPort")
+
+    want := Config{
+        {Key: "SocksPort", Value: "9050"},
+        {Key: "ExitPolicy", Value: "reject *:*"},
+        {Key: "SocksPort", Value: "9150"},
+        {Key: "ContactInfo", IsDefault: true},
+        {Key: "SocksPort", IsDefault: true},
+    }
+    if !reflect.DeepEqual(c, want) { t.Fatalf("Expected %v, got %v", want, c) }
+
+    if v, ok := c.Get("SocksPort"); !ok || v != "9050" { t.Errorf("Expected first SocksPort 9050, got %q %t", v, ok) }
+    if v, ok := c.Get("ContactInfo"); ok { t.Errorf("Expected ContactInfo unset, got %q", v) }
+    if v, ok := c.Get("Nickname"); ok { t.Errorf("Expected Nickname absent, got %q", v) }
+    if v := c.GetAll("SocksPort"); !reflect.DeepEqual(v, []string{"9050", "9150"}) { t.Errorf("Expected SocksPorts [9050 9150], got %v", v) }
+    if v := c.GetAll("Nickname"); len(v) != 0 { t.Errorf("Expected no Nickname, got %v", v) }
+    if v := c.Keys(); !reflect.DeepEqual(v, []string{"SocksPort", "ExitPolicy", "ContactInfo"}) { t.Errorf("Unexpected keys %v", v) }
+
+    if s := c[1].String(); s != "ExitPolicy=reject *:*" { t.Errorf("Unexpected entry %q", s) }
+    if s := c[3].String(); s != "ContactInfo" { t.Errorf("Unexpected default entry %q", s) }
+
+    // A trailing key without a value is dropped.
+    if c := NewConfig("SocksPort", "9050", "Nickname"); len(c) != 1 { t.Errorf("Expected 1 entry, got %v", c) }
+}
+
+func TestGetConfResponse(t *testing.T) {
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETCONF") {
+            return []string{"250-SocksPort=9050", "250-SocksPort=\"9150 IsolateDestAddr\"", "250-ExitPolicy", "250 Nickname=relay"}
+        }
+        return fakeReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    response, e := c.GetConf([]string{"SocksPort", "ExitPolicy", "Nickname"})
+    if e != nil { t.Fatal(e) }
+
+    want := Config{
+        {Key: "SocksPort", Value: "9050"},
+        {Key: "SocksPort", Value: "9150 IsolateDestAddr"},
+        {Key: "ExitPolicy", IsDefault: true},
+        {Key: "Nickname", Value: "relay"},
+    }
+    if v := response.ValueAll(); !reflect.DeepEqual(v, want) { t.Errorf("Expected %v, got %v", want, v) }
+
+    if v := response.ValuesOf("SocksPort"); !reflect.DeepEqual(v, []string{"9050", "9150 IsolateDestAddr"}) { t.Errorf("Unexpected SocksPorts %v", v) }
+    if v, ok := response.ValueOf("SocksPort"); !ok || v != "9050" { t.Errorf("Expected first SocksPort 9050, got %q %t", v, ok) }
+    if v, ok := response.ValueOf("ExitPolicy"); ok { t.Errorf("Expected ExitPolicy at its default, got %q", v) }
+    if v, ok := response.Value(); ok { t.Errorf("Expected no single value, got %q", v) }
+}
+
+func TestSetConfEncoding(t *testing.T) {
+    var mutex sync.Mutex
+    lines := make([]string, 0)
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "SETCONF") || strings.HasPrefix(line, "RESETCONF") {
+            mutex.Lock()
+            lines = append(lines, line)
+            mutex.Unlock()
+            return []string{"250 OK"}
+        }
+        return fakeReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    // Each HiddenServicePort belongs to the HiddenServiceDir before it.
+    config := NewConfig(
+        "HiddenServiceDir", "/var/lib/tor/a",
+        "HiddenServicePort", "80 127.0.0.1:8080",
+        "HiddenServiceDir", "/var/lib/tor/b",
+        "HiddenServicePort", "22 127.0.0.1:22",
+        "HiddenServicePort", "443 127.0.0.1:8443",
+    ).AddDefault("ContactInfo")
+
+    if _, e := c.SetConf(config); e != nil { t.Fatal(e) }
+    if _, e := c.ResetConf(NewConfig("SocksPort", "9050").AddDefault("ExitPolicy")); e != nil { t.Fatal(e) }
+
+    want := []string{
+        "SETCONF HiddenServiceDir=/var/lib/tor/a HiddenServicePort=\"80 127.0.0.1:8080\" HiddenServiceDir=/var/lib/tor/b HiddenServicePort=\"22 127.0.0.1:22\" HiddenServicePort=\"443 127.0.0.1:8443\" ContactInfo",
+        "RESETCONF SocksPort=9050 ExitPolicy",
+    }
+    mutex.Lock()
+    defer mutex.Unlock()
+    if !reflect.DeepEqual(lines, want) { t.Errorf("Expected %q, got %q", want, lines) }
+}
@@ -43,26 +43,52 @@
 package torc
 
 import (
+    "context"
+    "errors"
     "fmt"
     "net"
-    "os"
     "time"
-    "reflect"
+    "sync"
+    "sync/atomic"
 )
 
 // Function template for dialer parameter.
 type DialerFunc func(string, string) (net.Conn, error)
 
+// Function template for context aware dialer parameter.
+type ContextDialerFunc func(context.Context, string, string) (net.Conn, error)
+
+// Function template for asynchronous event handlers, see AddEventHandler().
+type EventHandler func(Event)
+
+// A request awaiting its reply from the control socket.
+type pendingRequest struct {
+    request ControlRequest
+    reply   chan ResponseBuffer
+    failure chan error
+
+    // Set once the requester has given up waiting.
+    abandoned bool
+}
+
 // The Controller type handles connecting, disconnecting, authenticating,
 // sending & receiving of messages, event dispatching and command invokation.
 // You may supply a custom dialer function for connecting to the control socket
 // through a proxy, or some other connection means by replacing the DialerFunc
-// ``Dialer'' property before calling Connect().
+// ``Dialer'' property before calling Connect(). If the dial should be
+// cancellable, set the ``ContextDialer'' property instead, which takes
+// precedence when set.
 //
 // Once a connection is established you may use the Controller Command API to
 // send command requests and receive responses. Authentication is handled
-// automatically, though when a Password is required you must specify one before
-// you call the Connect() method.
+// automatically, though when a password is required you must specify a
+// PasswordProvider before you call the Connect() method. The authentication
+// methods attempted, and their order, may be customised with
+// SetAuthenticators().
+//
+// A connected Controller is safe for concurrent use by multiple goroutines,
+// requests are transmitted one at a time and each reply is handed to the
+// request that owns it.
 //
 type Controller struct {
     // The function to use for dialing remote connection.
@@ -70,38 +96,143 @@ type Controller struct {
     network  string
     hostport string
 
+    // Optional context aware function to use for dialing remote connection.
+    ContextDialer ContextDialerFunc
+
     // Control socket connection instance.
     connection *net.Conn
 
     isConnected bool
 
+    // Current connection state and registered state change handlers.
+    state             ConnectionState
+    stateHandlers     []registeredStateHandler
+    stateHandlerCount uint64
+    stateMutex        sync.Mutex
+
+    // Closed by Close() to stop reconnection attempts.
+    stop chan struct{}
+
+    // Closed once the connection is gone for good, along with the reason.
+    done chan struct{}
+    err  error
+
+    // Closed when the reader of the current connection stops.
+    readerDone chan struct{}
+
+    // Why the current connection was torn down, if it was.
+    teardownReason error
+
+    // How long Close() waits for Tor to acknowledge QUIT, defaults to
+    // DEFAULT_CLOSE_TIMEOUT.
+    CloseTimeout time.Duration
+
+    // Optional automatic reconnection policy, nil disables reconnection.
+    ReconnectPolicy *ReconnectPolicy
+
+    // Optional connection health check policy, nil disables heartbeats.
+    HeartbeatPolicy *HeartbeatPolicy
+
+    // Event subscription and onion services restored on reconnect.
+    activeEvents []string
+    onions       map[string]*onionRecord
+    restoreMutex sync.Mutex
+
+    // Reference counts of events subscribed to with Subscribe().
+    subscriptions  map[string]int
+    subscribeMutex sync.Mutex
+
+    // Event names supported by Tor, fetched once per connection.
+    eventNames []string
+
     // Incoming response message queue.
     in chan ResponseBuffer
 
+    // Serializes writes to the control socket, along with pending queue
+    // insertion so the queue order always matches transmission order.
+    writeMutex sync.Mutex
+
+    // Requests awaiting a reply, in order of transmission.
+    pending      []*pendingRequest
+    pendingMutex sync.Mutex
+
+    // Number of requests transmitted.
+    requestCount atomic.Uint64
+
+    // How to recover when a request times out, defaults to discarding the late
+    // reply when it eventually arrives.
+    TimeoutAction TimeoutAction
+
+    // Incoming asynchronous event queue.
+    events chan ResponseBuffer
+
+    // Maximum number of events awaiting dispatch, defaults to
+    // DEFAULT_EVENT_QUEUE_SIZE. Once reached the oldest are dropped.
+    EventQueueSize int
+
+    // Number of events dropped from the full dispatch queue.
+    droppedEvents atomic.Uint64
+
+    // Registered event handlers keyed by event name, and subscriptions.
+    handlers      map[string][]EventHandler
+    subscribers   []*Subscription
+    handlersMutex sync.RWMutex
+
     // Incoming message parser instance.
     parser *Parser
 
-    // Optional password to use during authentication.
-    Password        string
+    // Protocol violations reported by parsers.
+    protocolErrors chan error
+
+    // Optional source of the password to use during authentication, fetched
+    // only when authenticating. Set before Connect(), afterwards use
+    // SetPasswordProvider().
+    PasswordProvider PasswordProvider
+
+    // Optional password to use during authentication. Set before Connect().
+    //
+    // Deprecated: A string can't be zeroed, so the password stays in memory
+    // for as long as the Controller does. Use PasswordProvider instead.
+    Password        Secret
 
+    // Authentication methods in order of preference, and the one in use,
+    // guarded by stateMutex.
+    authenticators  []Authenticator
     authenticator   Authenticator
     isAuthenticated bool
+
+    // Optional structured logger, such as a *slog.Logger, nil discards log
+    // output. Traffic on the control socket is logged at debug level.
+    Logger Logger
+}
+
+// Returns the Logger to write to, discarding output when none is set.
+func (c *Controller) logger() Logger {
+    if c.Logger == nil { return nopLogger{} }
+    return c.Logger
 }
 
 // Creates a new Controller instance, for connecting to a Tor service's
 // control socket through the specified dialer network and hostport
-// parameters.
+// parameters. For a ControlSocket use the "unix" network with the socket path
+// as hostport, or use DiscoverController() to locate the control socket.
 func NewController(network, hostport string) *Controller {
     c := new(Controller)
 
-    if len(os.Getenv("TORC_LOG_COMMS")) > 0 {
-       COMMS_LOGGING = true
-    }
-
     c.Dialer   = net.Dial
     c.network  = network
     c.hostport = hostport
 
+    c.handlers = make(map[string][]EventHandler)
+    c.onions   = make(map[string]*onionRecord)
+
+    c.subscriptions = make(map[string]int)
+
+    c.protocolErrors = make(chan error, 16)
+    c.done           = make(chan struct{})
+
+    c.authenticators = DefaultAuthenticators()
+
     return c
 }
 
@@ -110,117 +241,573 @@ func NewController(network, hostport string) *Controller {
 // automated authentication takes over. If no error occurs during this process
 // then you may begin to perform API requests.
 func (c *Controller) Connect() error {
+    return c.ConnectContext(context.Background())
+}
+
+// Connect this controller to the control socket of a Tor service, as with
+// Connect(). The context bounds dialing and authentication, cancelling it
+// aborts the attempt.
+func (c *Controller) ConnectContext(ctx context.Context) error {
     if c.IsConnected() {
-        LogInfo("Attempt to dial when already connected, failing silently.")
+        c.logger().Debug("Attempt to dial when already connected, failing silently.")
         return nil
     }
 
-    LogInfo("Attemping to dial remote: (%s) %s", c.network, c.hostport)
-    conn, e := c.Dialer(c.network, c.hostport)
+    c.stateMutex.Lock()
+    c.stop = make(chan struct{})
+    c.stateMutex.Unlock()
+
+    if e := c.connect(ctx); e != nil {
+        c.setState(STATE_CLOSED, e)
+        return e
+    }
+
+    c.setState(STATE_READY, nil)
+    c.startHeartbeat()
+    return nil
+}
+
+// Start sending heartbeats on the current connection, see HeartbeatPolicy.
+func (c *Controller) startHeartbeat() {
+    c.stateMutex.Lock()
+    readerDone, stop := c.readerDone, c.stop
+    c.stateMutex.Unlock()
+
+    go c.heartbeat(readerDone, stop)
+}
+
+// Dial, start the reader and authenticate. On failure any established
+// connection is closed again.
+func (c *Controller) connect(ctx context.Context) error {
+    c.setState(STATE_DIALING, nil)
+
+    if e := c.open(ctx); e != nil {
+        return e
+    }
+
+    c.setState(STATE_AUTHENTICATING, nil)
+    if e := c.authenticate(ctx); e != nil {
+        c.closeConnection()
+        return e
+    }
+
+    c.logger().Info("Successfully authenticated controller.", "method", c.Authenticator().MethodName())
+    return nil
+}
+
+// Dial the remote and start the reader goroutines for the new connection.
+func (c *Controller) open(ctx context.Context) error {
+    c.logger().Debug("Dialing control socket.", "network", c.network, "address", c.hostport)
+    conn, e := c.dial(ctx)
     if e != nil {
-        LogInfo("Failed to connect to remote: %v", e)
+        c.logger().Warn("Failed to connect to control socket.", "error", e)
         return e
     }
 
-    LogInfo("Connection established.")
+    c.logger().Debug("Connection established.")
+    c.writeMutex.Lock()
     c.connection = &conn
-    c.isConnected = true
-
-    c.in = make(chan ResponseBuffer, 1)
-    c.parser = NewParser(conn, c.in)
+    c.writeMutex.Unlock()
+
+    in := make(chan ResponseBuffer, 1)
+    events := make(chan ResponseBuffer, 16)
+    parser := NewParser(conn, in, events)
+    parser.SetLogger(c.logger())
+
+    readerDone := make(chan struct{})
+
+    c.stateMutex.Lock()
+    c.in = in
+    c.events = events
+    c.parser = parser
+    c.readerDone = readerDone
+    c.teardownReason = nil
+    c.eventNames = nil
+    c.stateMutex.Unlock()
+
+    // Kickstart reader/parser and event dispatcher goroutines.
+    c.logger().Debug("Starting reader.")
+    go func() {
+        parser.Run()
+        close(readerDone)
+        c.connectionLost(parser, conn)
+    }()
+    go c.routeReplies(in)
+    queue := newEventQueue(c.EventQueueSize, &c.droppedEvents)
+    go queue.fill(events)
+    go c.dispatchEvents(queue)
+    go c.forwardErrors(parser.Errors())
 
-    // Kickstart reader/parser goroutine.
-    LogInfo("Starting reader.")
-    go c.parser.Run()
+    return nil
+}
 
+// Authenticate with each of the preferred authentication methods advertised by
+// PROTOCOLINFO in turn, until one succeeds. Tor closes the connection once it
+// rejects an attempt, so the connection is reopened before falling back to the
+// next method whenever the failed attempt sent any request. There's no falling
+// back after ErrServerHashMismatch.
+func (c *Controller) authenticate(ctx context.Context) error {
     // Send PROTOCOLINFO request to get authentication mechanisms.
-    protoinfo, e := c.ProtocolInfo()
+    protoinfo, e := c.ProtocolInfoContext(ctx)
     if e != nil {
-        LogInfo("PROTOCOLINFO request failed: %v", e)
+        c.logger().Warn("PROTOCOLINFO request failed.", "error", e)
         return e
     }
 
-    // TODO: Expose an API for managing user preferences?
-    authPrefs := []Authenticator{
-                     &CookieAuthenticator{},
-                     &PasswordAuthenticator{},
-                     &OpenAuthenticator{},
-                 }
+    failure := &AuthError{Advertised: protoinfo.AuthMethods()}
+    reopen := false
+
+    c.stateMutex.Lock()
+    c.authenticator, c.isAuthenticated = nil, false
+    c.stateMutex.Unlock()
+
+    for _, i := range c.Authenticators() {
+        if !__contains(failure.Advertised, i.MethodName()) { continue }
+
+        if reopen {
+            c.logger().Debug("Reopening connection to try next authentication method.", "method", i.MethodName())
+            c.closeConnection()
+            if e := c.open(ctx); e != nil { return e }
+
+            if protoinfo, e = c.ProtocolInfoContext(ctx); e != nil {
+                c.logger().Warn("PROTOCOLINFO request failed.", "error", e)
+                return e
+            }
+        }
 
-    // Automatically Select prefered authentication method.
-    for _, i := range authPrefs {
-        for _, v := range protoinfo.AuthMethods() {
-            if i.MethodName() != v { continue }
-            c.authenticator = i
-            break
+        sent := c.requestCount.Load()
+        e := i.Authenticate(ctx, c, protoinfo)
+        if e == nil {
+            c.stateMutex.Lock()
+            c.authenticator, c.isAuthenticated = i, true
+            c.stateMutex.Unlock()
+            return nil
         }
+
+        c.logger().Warn("Authentication failed.", "method", i.MethodName(), "error", e)
+        failure.Attempts = append(failure.Attempts, AuthAttempt{i.MethodName(), e})
+        reopen = c.requestCount.Load() != sent
+
+        // Falling back to a weaker method would hand an impersonator the
+        // secret SAFECOOKIE just refused to reveal.
+        if errors.Is(e, ErrServerHashMismatch) { break }
+        if ctx.Err() != nil { break }
     }
 
-    if c.authenticator == nil {
-        return fmt.Errorf("Failed to find compatible authentication method.")
+    return failure
+}
+
+// Dial the remote using ContextDialer when set, otherwise Dialer. As Dialer
+// can't be cancelled, a connection it establishes after ctx is done is closed.
+func (c *Controller) dial(ctx context.Context) (net.Conn, error) {
+    if c.ContextDialer != nil {
+        return c.ContextDialer(ctx, c.network, c.hostport)
     }
 
-    if e := c.authenticator.Authenticate(c, protoinfo); e != nil {
-        return fmt.Errorf("Authentication failed!")
+    type result struct {
+        conn net.Conn
+        e    error
     }
 
-    LogInfo("Successfully authenticated controller.")
-    return nil
-}
+    ch := make(chan result, 1)
+    go func() {
+        conn, e := c.Dialer(c.network, c.hostport)
+        ch<- result{conn, e}
+    }()
 
-// Close this Controller instances connection to Tor service.
-func (c *Controller) Close() {
-    if c.connection == nil {
-        return
+    select {
+        case r := <-ch:
+            return r.conn, r.e
+
+        case <-ctx.Done():
+            go func() {
+                if r := <-ch; r.conn != nil { r.conn.Close() }
+            }()
+            return nil, ctx.Err()
     }
+}
 
-    (*c.connection).Close()
+// Close this Controller instances connection to Tor service, this also stops
+// any reconnection attempts. When ready, QUIT is sent first so Tor closes the
+// connection gracefully, waiting at most CloseTimeout for it to acknowledge.
+// Requests still in flight fail with ErrClosed, and the reader has stopped by
+// the time Close returns.
+func (c *Controller) Close() error {
+    // The closed channel is kept, so a reader noticing the connection is gone
+    // afterwards still knows not to reconnect.
+    c.stateMutex.Lock()
+    if c.stop != nil {
+        select {
+            case <-c.stop:
+            default:
+                close(c.stop)
+        }
+    }
+    ready := c.state == STATE_READY || c.state == STATE_DEGRADED
+    readerDone := c.readerDone
+    c.stateMutex.Unlock()
 
-    close(c.in)
+    if ready { c.quit() }
 
+    c.writeMutex.Lock()
+    conn := c.connection
     c.connection = nil
-    c.isConnected = false
+    c.writeMutex.Unlock()
+
+    var e error
+    if conn != nil {
+        // The reader closes the message queues once the connection is closed.
+        if e = (*conn).Close(); errors.Is(e, net.ErrClosed) { e = nil }
+    }
+    if readerDone != nil { <-readerDone }
+
+    c.failPending(ErrClosed)
+    c.stateMutex.Lock()
     c.isAuthenticated = false
+    c.stateMutex.Unlock()
+    select {
+        case <-c.Done():
+        default:
+            c.setState(STATE_CLOSED, ErrClosed)
+    }
+    return e
+}
+
+// Ask Tor to close the connection, waiting at most CloseTimeout for it to
+// acknowledge with "250 closing connection".
+func (c *Controller) quit() {
+    timeout := c.CloseTimeout
+    if timeout <= 0 { timeout = DEFAULT_CLOSE_TIMEOUT }
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    if _, e := Do[BaseControlResponse](ctx, c, NewRequest(COMMAND_QUIT)); e != nil {
+        c.logger().Debug("QUIT request failed.", "error", e)
+    }
+}
+
+// Returns a channel that's closed once the connection is gone for good, after
+// Close(), a failed Connect(), or losing the connection without reconnecting.
+// Connecting again replaces the channel.
+func (c *Controller) Done() <-chan struct{} {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    return c.done
+}
+
+// Returns the reason the Done() channel was closed, ErrClosed after Close(), or
+// nil while it's still open.
+func (c *Controller) Err() error {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    return c.err
+}
+
+// Close the current connection, if any. The connection is cleared by the
+// reader once it notices, see connectionLost().
+func (c *Controller) closeConnection() {
+    c.writeMutex.Lock()
+    conn := c.connection
+    c.writeMutex.Unlock()
+
+    if conn != nil { (*conn).Close() }
 }
 
 // Returns true if Controller instance believes it's connected.
 func (c *Controller) IsConnected() bool {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
     return c.isConnected
 }
 
 // Returns true if Controller instance has been authenticated.
 func (c *Controller) IsAuthenticated() bool {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
     return c.isAuthenticated
 }
 
-// Send message through control socket.
+// Registers handler to be invoked for each asynchronous event named event, as
+// subscribed to with the SetEvents command method. Events are decoded with
+// DecodeEvent before being handed to the handler. Handlers are invoked in
+// order of registration from the event dispatcher goroutine. Events queue up
+// to EventQueueSize behind a slow handler, and are then dropped, which never
+// delays command replies but does delay every other handler and subscription,
+// so they should not block for long periods of time. Use Subscribe for
+// per-consumer buffering.
+func (c *Controller) AddEventHandler(event string, handler EventHandler) {
+    c.handlersMutex.Lock()
+    defer c.handlersMutex.Unlock()
+    c.handlers[event] = append(c.handlers[event], handler)
+}
+
+// Removes all handlers registered for the named event.
+func (c *Controller) RemoveEventHandlers(event string) {
+    c.handlersMutex.Lock()
+    defer c.handlersMutex.Unlock()
+    delete(c.handlers, event)
+}
+
+// Returns the number of events dropped since the Controller was created,
+// because handlers or subscriptions fell EventQueueSize events behind.
+func (c *Controller) DroppedEvents() uint64 {
+    return c.droppedEvents.Load()
+}
+
+// Dispatch incoming asynchronous events to registered handlers and
+// subscriptions, until the queue is closed and drained.
+func (c *Controller) dispatchEvents(queue *eventQueue) {
+    for {
+        buff, ok := queue.pop()
+        if !ok { return }
+
+        c.handlersMutex.RLock()
+        handlers := c.handlers[buff.EventName()]
+        subscribers := make([]*Subscription, 0)
+        for _, s := range c.subscribers {
+            if __contains(s.types, buff.EventName()) { subscribers = append(subscribers, s) }
+        }
+        c.handlersMutex.RUnlock()
+
+        if len(handlers) == 0 && len(subscribers) == 0 {
+            c.logger().Debug("Unhandled event.", "event", buff.EventName())
+            continue
+        }
+
+        event, e := DecodeEvent(buff)
+        if e != nil {
+            c.logger().Warn("Failed to decode event.", "event", buff.EventName(), "error", e)
+        }
+
+        for _, handler := range handlers {
+            handler(event)
+        }
+        for _, s := range subscribers {
+            s.deliver(event)
+        }
+    }
+}
+
+// Hand incoming replies to pending requests in order of transmission, until
+// the replies channel is closed.
+func (c *Controller) routeReplies(in chan ResponseBuffer) {
+    for buff := range in {
+        c.pendingMutex.Lock()
+        if len(c.pending) == 0 {
+            c.pendingMutex.Unlock()
+            c.logger().Warn("Discarding unsolicited reply.", "status", buff.EndReplyLine.Status())
+            continue
+        }
+        p := c.pending[0]
+        c.pending = c.pending[1:]
+        abandoned := p.abandoned
+        c.pendingMutex.Unlock()
+
+        if abandoned {
+            c.logger().Debug("Discarding late reply to abandoned request.", "status", buff.EndReplyLine.Status())
+            continue
+        }
+
+        // Reply channels are buffered, so this never blocks.
+        p.reply<- buff
+    }
+}
+
+// Fail and dequeue all pending requests with the error e.
+func (c *Controller) failPending(e error) {
+    c.pendingMutex.Lock()
+    pending := c.pending
+    c.pending = nil
+    c.pendingMutex.Unlock()
+
+    for _, p := range pending {
+        p.failure<- e
+    }
+}
+
+// Tear down a broken connection, failing all pending requests with reason.
+func (c *Controller) teardown(reason error) {
+    c.logger().Warn("Tearing down connection.", "reason", reason)
+
+    c.stateMutex.Lock()
+    c.teardownReason = reason
+    c.stateMutex.Unlock()
+
+    c.closeConnection()
+    c.failPending(fmt.Errorf("Connection torn down: %w", reason))
+}
+
+// Returns the channel protocol violations found in replies are reported on, as
+// ProtocolError instances. Violations are dropped when the channel is full.
+func (c *Controller) ProtocolErrors() <-chan error {
+    return c.protocolErrors
+}
+
+// Forward protocol violations from a parser, until it stops.
+func (c *Controller) forwardErrors(errors <-chan error) {
+    for e := range errors {
+        select {
+            case c.protocolErrors<- e:
+            default:
+        }
+    }
+}
+
+// Send message through control socket. Note that any reply to the message is
+// not awaited, it's read and discarded, use Request() to send commands.
 func (c *Controller) SendMessage(buffer LineBuffer) error {
-    LogComms("<<", buffer)
-    _, e := (*c.connection).Write(buffer.Normalize())
-    return e
+    p := &pendingRequest{
+        reply:     make(chan ResponseBuffer, 1),
+        failure:   make(chan error, 1),
+        abandoned: true,
+    }
+
+    c.writeMutex.Lock()
+    defer c.writeMutex.Unlock()
+    return c.enqueue(p, buffer.Normalize(), buffer)
+}
+
+// Queue p to await the reply to data, and transmit it, logging it as lines.
+// Replies are routed in order, so every message written must be queued. Must
+// hold writeMutex.
+func (c *Controller) enqueue(p *pendingRequest, data []byte, lines LineBuffer) error {
+    if c.connection == nil {
+        return ErrClosed
+    }
+
+    c.pendingMutex.Lock()
+    c.pending = append(c.pending, p)
+    c.pendingMutex.Unlock()
+
+    logComms(c.logger(), COMMS_SEND, lines)
+    if _, e := (*c.connection).Write(data); e != nil {
+        // The queue may have been failed meanwhile, so p may be gone already.
+        c.pendingMutex.Lock()
+        for i, v := range c.pending {
+            if v == p {
+                c.pending = append(c.pending[:i], c.pending[i+1:]...)
+                break
+            }
+        }
+        c.pendingMutex.Unlock()
+        return e
+    }
+    return nil
+}
+
+// Transmit request and queue it to await its reply.
+func (c *Controller) send(ctx context.Context, request ControlRequest) (*pendingRequest, error) {
+    p := &pendingRequest{
+        request: request,
+        reply:   make(chan ResponseBuffer, 1),
+        failure: make(chan error, 1),
+    }
+
+    c.writeMutex.Lock()
+    defer c.writeMutex.Unlock()
+
+    if c.connection == nil {
+        return nil, ErrClosed
+    }
+
+    if deadline, ok := ctx.Deadline(); ok {
+        (*c.connection).SetWriteDeadline(deadline)
+        defer (*c.connection).SetWriteDeadline(time.Time{})
+    }
+
+    // Raw requests are written as is, so their bytes can be zeroed afterwards.
+    lines := request.Serialize()
+    var data []byte
+    if raw, ok := request.(*rawRequest); ok {
+        data = raw.line
+    } else {
+        data = lines.Normalize()
+    }
+
+    if e := c.enqueue(p, data, lines); e != nil {
+        return nil, e
+    }
+
+    c.requestCount.Add(1)
+    return p, nil
 }
 
-// Send request through control socket, and populate response with reply.
-func (c *Controller) Request(request ControlRequest, response interface{}) error {
-    e := c.SendMessage(request.Serialize())
+// Send request through control socket, and populate response with reply, see
+// RequestContext().
+func (c *Controller) Request(request ControlRequest, response ResponseDecoder) error {
+    return c.RequestContext(context.Background(), request, response)
+}
+
+// Send request through control socket, and populate response with reply. The
+// context bounds the time spent waiting for the reply, when it carries no
+// deadline the request's ResponseTimeout() is applied instead. A negative
+// completion reply still populates response, and is returned as *ReplyError.
+func (c *Controller) RequestContext(ctx context.Context, request ControlRequest, response ResponseDecoder) error {
+    return c.requestContext(ctx, request, response, c.TimeoutAction)
+}
+
+// Perform request as with RequestContext(), recovering from a timeout with
+// action rather than the configured TimeoutAction.
+func (c *Controller) requestContext(ctx context.Context, request ControlRequest, response ResponseDecoder, action TimeoutAction) error {
+    if _, ok := ctx.Deadline(); !ok && request.ResponseTimeout() > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, request.ResponseTimeout())
+        defer cancel()
+    }
+
+    p, e := c.send(ctx, request)
     if e != nil {
-        LogError("Failed to send request: %v", e)
+        c.logger().Error("Failed to send request.", "error", e)
         return e
     }
 
     // Wait for reply.
     select {
-        case buff := <-c.in:
-            r := NewResponse(request, buff)
-            // VOODOO FOR SETTING BASE INSTANCE
-            v := reflect.ValueOf(response).Elem()
-            v.Field(0).Set(reflect.ValueOf(r))
-            return nil
+        case buff := <-p.reply:
+            e := response.DecodeResponse(request, buff)
+            if failure := NewResponse(request, buff).Err(); failure != nil {
+                return failure
+            }
+            return e
+
+        case e := <-p.failure:
+            return e
+
+        case <-ctx.Done():
+            c.logger().Warn("Gave up waiting for reply.", "error", ctx.Err())
+    }
+
+    return c.abandon(p, ctx.Err(), action)
+}
+
+// Send request through control socket, and return its reply decoded as a new
+// R, see RequestContext(). Any type embedding BaseControlResponse may be used
+// for R, allowing commands to be defined outside of this package, e.g.
+//
+//     type HSFetchResponse struct { torc.BaseControlResponse }
+//
+//     response, e := torc.Do[HSFetchResponse](ctx, c, torc.NewRequest("HSFETCH ..."))
+func Do[R any, PR interface { *R; ResponseDecoder }](ctx context.Context,
+                                                    c *Controller,
+                                                    request ControlRequest) (PR, error) {
+    response := PR(new(R))
+    return response, c.RequestContext(ctx, request, response)
+}
+
+// Abandon a pending request whose context ended with e, recovering the
+// connection with action.
+func (c *Controller) abandon(p *pendingRequest, e error, action TimeoutAction) error {
+    timeout := &RequestTimeoutError{p.request, action, e}
+
+    c.pendingMutex.Lock()
+    p.abandoned = true
+    c.pendingMutex.Unlock()
 
-        case <-time.After(request.ResponseTimeout()):
-            LogWarn("Timeout waiting for reply.")
+    if action == TIMEOUT_CLOSE_CONNECTION {
+        c.teardown(timeout)
     }
 
-    return fmt.Errorf("Timeout waiting for reply.")
+    return timeout
 }
 
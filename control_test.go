@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Answers the requests a Controller makes while connecting, anything else is
+// rejected as unrecognized.
+func fakeReply(line string) []string {
+    switch {
+    case strings.HasPrefix(line, "PROTOCOLINFO"):
+        return []string{"250-PROTOCOLINFO 1", "250-AUTH METHODS=NULL", "250-VERSION Tor=\"0.4.8.1\"", "250 OK"}
+    case strings.HasPrefix(line, "AUTHENTICATE"), strings.HasPrefix(line, "SETEVENTS"):
+        return []string{"250 OK"}
+    case strings.HasPrefix(line, "GETINFO version"):
+        return []string{"250-version=0.4.8.1", "250 OK"}
+    case strings.HasPrefix(line, "QUIT"):
+        return []string{"250 closing connection"}
+    }
+    return []string{"510 Unrecognized command"}
+}
+
+// Returns a Controller that dials a stand-in for Tor answering each request
+// line with the lines returned by reply, and the channel the stand-in's end of
+// each connection is sent on.
+func newFakeTor(t *testing.T, reply func(string) []string) (*Controller, chan net.Conn) {
+    remotes := make(chan net.Conn, 16)
+
+    c := NewController("tcp", "127.0.0.1:9051")
+    c.Dialer = func(string, string) (net.Conn, error) {
+        local, remote := net.Pipe()
+        t.Cleanup(func() { remote.Close() })
+        remotes<- remote
+
+        go func() {
+            r := bufio.NewReader(remote)
+            for {
+                line, e := r.ReadString('\n')
+                if e != nil { return }
+                for _, v := range reply(strings.TrimSuffix(line, "\r\n")) {
+                    if _, e := remote.Write([]byte(v + "\r\n")); e != nil { return }
+                }
+            }
+        }()
+        return local, nil
+    }
+    t.Cleanup(func() { c.Close() })
+    return c, remotes
+}
+
+// Returns a Controller whose connection is one end of a net.Pipe, and the
+// other end standing in for Tor.
+func newPipeController(t *testing.T) (*Controller, net.Conn) {
+    local, remote := net.Pipe()
+    t.Cleanup(func() { local.Close(); remote.Close() })
+
+    c := NewController("tcp", "127.0.0.1:9051")
+    c.connection = &local
+    return c, remote
+}
+
+// Waits for the pending queue to reach n requests.
+func waitPending(t *testing.T, c *Controller, n int) {
+    for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+        c.pendingMutex.Lock()
+        count := len(c.pending)
+        c.pendingMutex.Unlock()
+        if count == n { return }
+    }
+    t.Fatalf("Pending queue never reached %d requests", n)
+}
+
+func TestSendFailedWhilePendingFailed(t *testing.T) {
+    c, remote := newPipeController(t)
+
+    // Nothing reads the pipe, so the write blocks until it's closed.
+    result := make(chan error, 1)
+    go func() {
+        _, e := c.send(context.Background(), NewRequest("GETINFO version"))
+        result<- e
+    }()
+
+    waitPending(t, c, 1)
+    c.failPending(ErrClosed)
+    remote.Close()
+
+    select {
+        case e := <-result:
+            if e == nil { t.Fatal("Expected send to fail") }
+        case <-time.After(time.Second):
+            t.Fatal("Timed out waiting for send to fail")
+    }
+    waitPending(t, c, 0)
+}
+
+func TestRequestAfterConnectionLost(t *testing.T) {
+    c, remotes := newFakeTor(t, fakeReply)
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    (<-remotes).Close()
+    select {
+        case <-c.Done():
+        case <-time.After(time.Second):
+            t.Fatal("Timed out waiting for connection loss")
+    }
+
+    result := make(chan error, 1)
+    go func() {
+        _, e := c.GetInfo([]string{"version"})
+        result<- e
+    }()
+
+    select {
+        case e := <-result:
+            if !errors.Is(e, ErrClosed) { t.Fatalf("Expected ErrClosed, got %v", e) }
+        case <-time.After(time.Second):
+            t.Fatal("Request written to lost connection")
+    }
+}
+
+func TestSendMessageReplyDiscarded(t *testing.T) {
+    c, _ := newFakeTor(t, fakeReply)
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    if e := c.SendMessage(NewRequest("SIGNAL BOGUS").Serialize()); e != nil { t.Fatal(e) }
+
+    // The 510 to SIGNAL mustn't be taken as the reply to GETINFO.
+    response, e := c.GetInfo([]string{"version"})
+    if e != nil { t.Fatal(e) }
+    if v, _ := response.Value(); v != "0.4.8.1" { t.Errorf("Expected version 0.4.8.1, got %q", v) }
+}
+
+func TestConcurrentRequests(t *testing.T) {
+    c, _ := newFakeTor(t, func(line string) []string {
+        if key, ok := strings.CutPrefix(line, "GETINFO key"); ok {
+            // Events interleaved between replies mustn't upset matching.
+            return []string{"650 BW 1 2", "250-key" + key + "=" + key, "250 OK"}
+        }
+        return fakeReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    var wg sync.WaitGroup
+    errs := make(chan error, 50)
+    for i := 0; i < 50; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            key := fmt.Sprintf("key%d", i)
+            response, e := c.GetInfo([]string{key})
+            if e != nil { errs<- e; return }
+            if v, _ := response.ValueOf(key); v != fmt.Sprint(i) { errs<- fmt.Errorf("%s got reply %q", key, v) }
+        }(i)
+    }
+    wg.Wait()
+    close(errs)
+    for e := range errs { t.Error(e) }
+}
+
+func TestLateReplyDiscarded(t *testing.T) {
+    release := make(chan struct{})
+    c, _ := newFakeTor(t, func(line string) []string {
+        switch line {
+        case "GETINFO slow":
+            <-release
+            return []string{"250-slow=1", "250 OK"}
+        case "GETINFO fast":
+            return []string{"250-fast=2", "250 OK"}
+        }
+        return fakeReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+
+    var timeout *RequestTimeoutError
+    _, e := c.GetInfoContext(ctx, []string{"slow"})
+    if !errors.As(e, &timeout) || timeout.Action != TIMEOUT_DISCARD_REPLY || !timeout.Timeout() {
+        t.Fatalf("Expected discarding RequestTimeoutError, got %v", e)
+    }
+
+    close(release)
+    response, e := c.GetInfo([]string{"fast"})
+    if e != nil { t.Fatal(e) }
+    if v, _ := response.ValueOf("fast"); v != "2" { t.Fatalf("Got reply meant for another request: %v", response.Buffer) }
+}
+
+func TestTimeoutTearsDownConnection(t *testing.T) {
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETINFO slow") { return nil }
+        return fakeReply(line)
+    })
+    c.TimeoutAction = TIMEOUT_CLOSE_CONNECTION
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    // Another request queued behind the one timing out fails along with it.
+    queued := make(chan error, 1)
+    go func() {
+        _, e := c.GetInfo([]string{"slow", "other"})
+        queued<- e
+    }()
+    waitPending(t, c, 1)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+    defer cancel()
+
+    var timeout *RequestTimeoutError
+    if _, e := c.GetInfoContext(ctx, []string{"slow"}); !errors.As(e, &timeout) || timeout.Action != TIMEOUT_CLOSE_CONNECTION {
+        t.Fatalf("Expected RequestTimeoutError closing the connection, got %v", e)
+    }
+    if e := <-queued; !errors.As(e, &timeout) { t.Fatalf("Expected queued request to fail with the teardown, got %v", e) }
+
+    select {
+        case <-c.Done():
+        case <-time.After(time.Second):
+            t.Fatal("Connection not torn down")
+    }
+    if c.State() != STATE_CLOSED { t.Fatalf("Expected Closed, got %s", c.State()) }
+}
+
+// Requests racing the loss of the connection must all fail cleanly.
+func TestConnectionLostDuringRequests(t *testing.T) {
+    c, remotes := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETINFO") { return nil }
+        return fakeReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+    remote := <-remotes
+
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if _, e := c.GetInfo([]string{"version"}); e == nil { t.Error("Expected request to fail") }
+        }()
+    }
+    time.Sleep(5 * time.Millisecond)
+    remote.Close()
+
+    done := make(chan struct{})
+    go func() { wg.Wait(); close(done) }()
+    select {
+        case <-done:
+        case <-time.After(time.Second):
+            t.Fatal("Requests still waiting after the connection was lost")
+    }
+}
+
+func TestCloseFailsPendingRequests(t *testing.T) {
+    // Tor answers in order, so once hung nothing more is answered.
+    hung := false
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETINFO hang") { hung = true }
+        if hung { return nil }
+        return fakeReply(line)
+    })
+    c.CloseTimeout = 20 * time.Millisecond
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    result := make(chan error, 1)
+    go func() {
+        _, e := c.GetInfo([]string{"hang"})
+        result<- e
+    }()
+    waitPending(t, c, 1)
+
+    if e := c.Close(); e != nil { t.Fatal(e) }
+    if e := <-result; !errors.Is(e, ErrClosed) { t.Fatalf("Expected ErrClosed, got %v", e) }
+    if e := c.Err(); !errors.Is(e, ErrClosed) { t.Fatalf("Expected Err() to be ErrClosed, got %v", e) }
+    if _, e := c.GetInfo([]string{"version"}); !errors.Is(e, ErrClosed) { t.Fatalf("Expected ErrClosed, got %v", e) }
+}
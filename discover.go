@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "strings"
+    "time"
+)
+
+// Environment variables consulted by DiscoverController, in order of
+// precedence.
+const (
+    ENV_CONTROL_SOCKET    = "TOR_CONTROL_SOCKET"
+    ENV_CONTROL_PORT_FILE = "TOR_CONTROL_PORT_FILE"
+    ENV_CONTROL_HOST      = "TOR_CONTROL_HOST"
+    ENV_CONTROL_PORT      = "TOR_CONTROL_PORT"
+)
+
+// Well known ControlSocket paths, tried in order by DiscoverController.
+var WellKnownControlSockets = []string{
+    "/run/tor/control",
+    "/var/run/tor/control",
+    "/var/lib/tor/control_socket",
+    "/usr/local/var/run/tor/control",
+}
+
+// Well known ControlPort addresses, tried in order by DiscoverController after
+// the control sockets. The second is used by Tor Browser.
+var WellKnownControlPorts = []string{
+    "127.0.0.1:9051",
+    "127.0.0.1:9151",
+}
+
+// How long DiscoverController waits when probing a well known ControlPort.
+var DiscoverProbeTimeout = time.Second
+
+// The ControlAddress type describes a control socket to dial, as passed to
+// NewController.
+type ControlAddress struct {
+    Network string
+    Address string
+}
+
+func (a ControlAddress) String() string {
+    return a.Network + ":" + a.Address
+}
+
+// Parses the output of Tor's ControlPortWriteToFile option, which contains one
+// "PORT=host:port" or "UNIX_PORT=path" line per listener. Entries of unknown
+// types are ignored.
+func ParseControlPortFile(r io.Reader) ([]ControlAddress, error) {
+    results := make([]ControlAddress, 0)
+
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        ln := strings.TrimSpace(scanner.Text())
+        if ln == "" { continue }
+
+        parts := strings.SplitN(ln, "=", 2)
+        if len(parts) != 2 {
+            return nil, fmt.Errorf("Malformed control port file line: %q", ln)
+        }
+
+        switch parts[0] {
+        case "PORT":
+            results = append(results, ControlAddress{"tcp", parts[1]})
+        case "UNIX_PORT":
+            results = append(results, ControlAddress{"unix", parts[1]})
+        }
+    }
+
+    return results, scanner.Err()
+}
+
+// Reads and parses a ControlPortWriteToFile output file, see
+// ParseControlPortFile.
+func ReadControlPortFile(path string) ([]ControlAddress, error) {
+    f, e := os.Open(path)
+    if e != nil { return nil, e }
+    defer f.Close()
+    return ParseControlPortFile(f)
+}
+
+// Locates the control socket of a local Tor service and returns a Controller
+// ready to Connect() to it. Explicit configuration is honoured first, without
+// probing:
+//
+//   1. TOR_CONTROL_SOCKET, the path of a ControlSocket.
+//   2. TOR_CONTROL_PORT_FILE, followed by any portFiles given, each the output
+//      of ControlPortWriteToFile. The first listed address is used.
+//   3. TOR_CONTROL_HOST and/or TOR_CONTROL_PORT, defaulting to 127.0.0.1 and
+//      9051 respectively.
+//
+// Otherwise the WellKnownControlSockets that exist, and then the
+// WellKnownControlPorts that accept a connection are tried in order.
+func DiscoverController(portFiles ...string) (*Controller, error) {
+    if path := os.Getenv(ENV_CONTROL_SOCKET); path != "" {
+        return NewController("unix", path), nil
+    }
+
+    if path := os.Getenv(ENV_CONTROL_PORT_FILE); path != "" {
+        portFiles = append([]string{path}, portFiles...)
+    }
+
+    tried := make([]string, 0)
+    for _, path := range portFiles {
+        addrs, e := ReadControlPortFile(path)
+        if e != nil {
+            tried = append(tried, fmt.Sprintf("%s (%v)", path, e))
+            continue
+        }
+        if len(addrs) == 0 {
+            tried = append(tried, fmt.Sprintf("%s (no listeners)", path))
+            continue
+        }
+        return NewController(addrs[0].Network, addrs[0].Address), nil
+    }
+
+    host, port := os.Getenv(ENV_CONTROL_HOST), os.Getenv(ENV_CONTROL_PORT)
+    if host != "" || port != "" {
+        if host == "" { host = "127.0.0.1" }
+        if port == "" { port = "9051" }
+        return NewController("tcp", net.JoinHostPort(host, port)), nil
+    }
+
+    for _, path := range WellKnownControlSockets {
+        info, e := os.Stat(path)
+        if e == nil && info.Mode() & os.ModeSocket != 0 {
+            return NewController("unix", path), nil
+        }
+        tried = append(tried, "unix:" + path)
+    }
+
+    for _, hostport := range WellKnownControlPorts {
+        conn, e := net.DialTimeout("tcp", hostport, DiscoverProbeTimeout)
+        if e == nil {
+            conn.Close()
+            return NewController("tcp", hostport), nil
+        }
+        tried = append(tried, "tcp:" + hostport)
+    }
+
+    return nil, fmt.Errorf("Failed to discover Tor control socket, tried: %s", strings.Join(tried, ", "))
+}
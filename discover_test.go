@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+
+package torc
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseControlPortFile(t *testing.T) {
+    tests := []struct {
+        name    string
+        input   string
+        want    []ControlAddress
+        failing bool
+    }{
+        {"PORT", "PORT=127.0.0.1:9051\n", []ControlAddress{{"tcp", "127.0.0.1:9051"}}, false},
+        {"UNIX_PORT", "UNIX_PORT=/run/tor/control\n", []ControlAddress{{"unix", "/run/tor/control"}}, false},
+        {"both in order", "UNIX_PORT=/run/tor/control\r\nPORT=[::1]:9051\r\n", []ControlAddress{{"unix", "/run/tor/control"}, {"tcp", "[::1]:9051"}}, false},
+        {"blank lines", "\n  PORT=127.0.0.1:9051  \n\n", []ControlAddress{{"tcp", "127.0.0.1:9051"}}, false},
+        {"unknown type ignored", "SOCKS=127.0.0.1:9050\nPORT=127.0.0.1:9051\n", []ControlAddress{{"tcp", "127.0.0.1:9051"}}, false},
+        {"empty", "", []ControlAddress{}, false},
+        {"malformed", "PORT 127.0.0.1:9051\n", nil, true},
+    }
+
+    for _, v := range tests {
+        t.Run(v.name, func(t *testing.T) {
+            addrs, e := ParseControlPortFile(strings.NewReader(v.input))
+            if (e != nil) != v.failing { t.Fatalf("Expected failure %t, got %v", v.failing, e) }
+            if !reflect.DeepEqual(addrs, v.want) { t.Errorf("Expected %v, got %v", v.want, addrs) }
+        })
+    }
+}
+
+// Writes a control port file holding content to a temporary directory.
+func writePortFile(t *testing.T, name, content string) string {
+    path := filepath.Join(t.TempDir(), name)
+    if e := os.WriteFile(path, []byte(content), 0600); e != nil { t.Fatal(e) }
+    return path
+}
+
+func TestDiscoverControllerPrecedence(t *testing.T) {
+    envPortFile := writePortFile(t, "env", "PORT=127.0.0.1:1111\n")
+    argPortFile := writePortFile(t, "arg", "UNIX_PORT=/tmp/arg.sock\n")
+    emptyPortFile := writePortFile(t, "empty", "")
+    missingPortFile := filepath.Join(t.TempDir(), "missing")
+
+    tests := []struct {
+        name      string
+        env       map[string]string
+        portFiles []string
+        want      ControlAddress
+    }{
+        {"socket first", map[string]string{ENV_CONTROL_SOCKET: "/tmp/env.sock", ENV_CONTROL_PORT_FILE: envPortFile, ENV_CONTROL_PORT: "2222"},
+            []string{argPortFile}, ControlAddress{"unix", "/tmp/env.sock"}},
+        {"port file env before arguments", map[string]string{ENV_CONTROL_PORT_FILE: envPortFile, ENV_CONTROL_PORT: "2222"},
+            []string{argPortFile}, ControlAddress{"tcp", "127.0.0.1:1111"}},
+        {"port file arguments", map[string]string{ENV_CONTROL_PORT: "2222"},
+            []string{argPortFile}, ControlAddress{"unix", "/tmp/arg.sock"}},
+        {"unusable port files skipped", map[string]string{ENV_CONTROL_PORT_FILE: missingPortFile},
+            []string{emptyPortFile, argPortFile}, ControlAddress{"unix", "/tmp/arg.sock"}},
+        {"host and port", map[string]string{ENV_CONTROL_HOST: "::1", ENV_CONTROL_PORT: "2222"},
+            nil, ControlAddress{"tcp", "[::1]:2222"}},
+        {"port only", map[string]string{ENV_CONTROL_PORT: "2222"}, []string{emptyPortFile}, ControlAddress{"tcp", "127.0.0.1:2222"}},
+        {"host only", map[string]string{ENV_CONTROL_HOST: "192.0.2.1"}, nil, ControlAddress{"tcp", "192.0.2.1:9051"}},
+    }
+
+    for _, v := range tests {
+        t.Run(v.name, func(t *testing.T) {
+            for _, k := range []string{ENV_CONTROL_SOCKET, ENV_CONTROL_PORT_FILE, ENV_CONTROL_HOST, ENV_CONTROL_PORT} {
+                t.Setenv(k, v.env[k])
+            }
+
+            c, e := DiscoverController(v.portFiles...)
+            if e != nil { t.Fatal(e) }
+            if got := (ControlAddress{c.network, c.hostport}); got != v.want { t.Errorf("Expected %s, got %s", v.want, got) }
+        })
+    }
+}
+
+func TestDiscoverControllerNothingFound(t *testing.T) {
+    for _, k := range []string{ENV_CONTROL_SOCKET, ENV_CONTROL_PORT_FILE, ENV_CONTROL_HOST, ENV_CONTROL_PORT} {
+        t.Setenv(k, "")
+    }
+
+    sockets, ports := WellKnownControlSockets, WellKnownControlPorts
+    t.Cleanup(func() { WellKnownControlSockets, WellKnownControlPorts = sockets, ports })
+
+    // A regular file isn't a ControlSocket.
+    WellKnownControlSockets = []string{writePortFile(t, "control", "")}
+    WellKnownControlPorts = nil
+
+    if _, e := DiscoverController(); e == nil || !strings.Contains(e.Error(), WellKnownControlSockets[0]) {
+        t.Fatalf("Expected failure listing %s, got %v", WellKnownControlSockets[0], e)
+    }
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// Returned, wrapped in an *ArgumentError, when a request argument can't be
+// encoded safely.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// The ArgumentError type describes a request argument rejected by the
+// RequestBuilder, it matches ErrInvalidArgument with errors.Is.
+type ArgumentError struct {
+    Argument string
+    Reason   string
+}
+
+func (e *ArgumentError) Error() string {
+    return fmt.Sprintf("Invalid argument %q: %s", e.Argument, e.Reason)
+}
+
+func (e *ArgumentError) Unwrap() error { return ErrInvalidArgument }
+
+// Returns s encoded as a control protocol QuotedString, escaping backslashes
+// and double quotes. Strings containing CR, LF or NUL can't be sent safely and
+// must be rejected with CheckArgument() first.
+func QuoteString(s string) string {
+    var result strings.Builder
+    result.WriteByte('"')
+    for i := 0; i < len(s); i++ {
+        switch s[i] {
+        case '"', '\\':
+            result.WriteByte('\\')
+            result.WriteByte(s[i])
+        case '\t':
+            result.WriteString("\\t")
+        default:
+            result.WriteByte(s[i])
+        }
+    }
+    result.WriteByte('"')
+    return result.String()
+}
+
+// Returns an *ArgumentError if s contains characters that would terminate the
+// request line, which could otherwise be used to inject further commands.
+func CheckArgument(s string) error {
+    if i := strings.IndexAny(s, "\r\n\x00"); i != -1 {
+        return &ArgumentError{s, "contains CR, LF or NUL"}
+    }
+    return nil
+}
+
+// Returns s as is when it may be sent as a bare value, or as a QuotedString.
+func EncodeValue(s string) (string, error) {
+    if e := CheckArgument(s); e != nil { return "", e }
+
+    if s == "" || strings.IndexAny(s, " \t\"\\") != -1 {
+        return QuoteString(s), nil
+    }
+    return s, nil
+}
+
+// The RequestBuilder type builds request lines from arguments, encoding each
+// according to the control spec so no value can break or inject a command.
+// The first invalid argument is reported by Build().
+type RequestBuilder struct {
+    args []string
+    e    error
+}
+
+// Instantiates a new RequestBuilder for command, e.g. COMMAND_SETCONF.
+func NewRequestBuilder(command string) *RequestBuilder {
+    b := new(RequestBuilder)
+    b.args = []string{command}
+    return b
+}
+
+func (b *RequestBuilder) fail(e error) *RequestBuilder {
+    if b.e == nil { b.e = e }
+    return b
+}
+
+// Appends a bare positional argument, such as a GETINFO key or a signal name.
+// Arguments that would need quoting are rejected.
+func (b *RequestBuilder) Arg(v string) *RequestBuilder {
+    if e := CheckArgument(v); e != nil { return b.fail(e) }
+    if v == "" || strings.IndexAny(v, " \t\"") != -1 {
+        return b.fail(&ArgumentError{v, "not a valid bare argument"})
+    }
+    b.args = append(b.args, v)
+    return b
+}
+
+// Appends each of args with Arg().
+func (b *RequestBuilder) Args(args ...string) *RequestBuilder {
+    for _, v := range args { b.Arg(v) }
+    return b
+}
+
+// Appends a positional argument as a QuotedString.
+func (b *RequestBuilder) Quoted(v string) *RequestBuilder {
+    if e := CheckArgument(v); e != nil { return b.fail(e) }
+    b.args = append(b.args, QuoteString(v))
+    return b
+}
+
+// Appends a keyword argument without a value, e.g. "Key" in RESETCONF.
+func (b *RequestBuilder) Key(key string) *RequestBuilder {
+    if !isKeyword(key) {
+        return b.fail(&ArgumentError{key, "not a valid keyword"})
+    }
+    b.args = append(b.args, key)
+    return b
+}
+
+// Appends a "Key=Value" keyword argument, quoting value when required.
+func (b *RequestBuilder) KeyValue(key, value string) *RequestBuilder {
+    if !isKeyword(key) {
+        return b.fail(&ArgumentError{key, "not a valid keyword"})
+    }
+    v, e := EncodeValue(value)
+    if e != nil { return b.fail(e) }
+    b.args = append(b.args, key + "=" + v)
+    return b
+}
+
+// Returns the encoded request line, or the first invalid argument.
+func (b *RequestBuilder) Line() (string, error) {
+    if b.e != nil { return "", b.e }
+    return strings.Join(b.args, " "), nil
+}
+
+// Returns the encoded request.
+func (b *RequestBuilder) Build() (*BaseControlRequest, error) {
+    line, e := b.Line()
+    if e != nil { return nil, e }
+    return NewRequest(line), nil
+}
+
+// Returns the encoded request as a multi-line request carrying body, see
+// NewMultilineRequest().
+func (b *RequestBuilder) BuildMultiline(body string) (*BaseControlRequest, error) {
+    line, e := b.Line()
+    if e != nil { return nil, e }
+    return NewMultilineRequest(line, body), nil
+}
+
+func isKeyword(s string) bool {
+    if s == "" { return false }
+    for i := 0; i < len(s); i++ {
+        if !__is_keyword_char(s[i]) { return false }
+    }
+    return true
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestRequestBuilderQuoting(t *testing.T) {
+    line, e := NewRequestBuilder(COMMAND_SETCONF).KeyValue("A", "x y").KeyValue("B", `q"\`).KeyValue("C", "").Key("D").Line()
+    if e != nil { t.Fatal(e) }
+    if want := `SETCONF A="x y" B="q\"\\" C="" D`; line != want { t.Fatalf("Expected %s, got %s", want, line) }
+}
+
+func TestRequestBuilderRejectsInjection(t *testing.T) {
+    tests := []*RequestBuilder{
+        NewRequestBuilder(COMMAND_SETCONF).KeyValue("A", "x\r\nSIGNAL HALT"),
+        NewRequestBuilder(COMMAND_GETINFO).Arg("a\nb"),
+        NewRequestBuilder(COMMAND_GETINFO).Arg("a b"),
+        NewRequestBuilder(COMMAND_SETCONF).KeyValue("A B", "x"),
+        NewRequestBuilder(COMMAND_SETCONF).Quoted("nul\x00"),
+    }
+    for i, v := range tests {
+        if _, e := v.Line(); !errors.Is(e, ErrInvalidArgument) { t.Errorf("Request %d: expected ErrInvalidArgument, got %v", i, e) }
+    }
+}
+
+func TestEncodeValue(t *testing.T) {
+    tests := map[string]string{
+        "plain":     "plain",
+        "":          `""`,
+        "two words": `"two words"`,
+        `q"`:        `"q\""`,
+    }
+    for value, want := range tests {
+        if got, e := EncodeValue(value); e != nil || got != want {
+            t.Errorf("EncodeValue(%q) = %s, %v, expected %s", value, got, e, want)
+        }
+    }
+    if _, e := EncodeValue("a\rb"); !errors.Is(e, ErrInvalidArgument) { t.Errorf("Expected ErrInvalidArgument, got %v", e) }
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// Returned by requests made once the connection is closed or lost, or in
+// flight when calling Controller.Close(), and reported by Controller.Err()
+// once closed.
+var ErrClosed = errors.New("controller closed")
+
+// Returned by SafeCookieAuthenticator when Tor fails to prove it knows the
+// cookie, suggesting the control port is impersonated. No further
+// authentication methods are attempted, so the cookie is never revealed.
+var ErrServerHashMismatch = errors.New("SAFECOOKIE server hash mismatch, control port may be impersonated")
+
+// Returned by PasswordAuthenticator when no PasswordProvider or Password is
+// set, rather than attempting authentication with an empty password.
+var ErrNoPassword = errors.New("no password configured")
+
+// Error classes of negative replies, according to the first and second
+// characters of the status code as described in message.go. Use these with
+// errors.Is on errors returned by command methods.
+var (
+    // 4yz, the command might succeed if reattempted later.
+    ErrTemporary = errors.New("temporary failure")
+
+    // 5yz, the command should not be reattempted as is.
+    ErrPermanent = errors.New("permanent failure")
+
+    // x0z, ill-formed or nonsensical command.
+    ErrSyntax    = errors.New("syntax error")
+
+    // x1z, failure of the control protocol itself.
+    ErrProtocol  = errors.New("control protocol error")
+
+    // x5z, failure of an operation of the Tor system.
+    ErrTor       = errors.New("tor error")
+)
+
+// Errors for the specific status codes defined by the control spec, use these
+// with errors.Is on errors returned by command methods.
+var (
+       ErrResourceExhausted = errors.New("resource exhausted")               // 451
+          ErrSyntaxProtocol = errors.New("syntax error: protocol")           // 500
+     ErrUnrecognizedCommand = errors.New("unrecognized command")             // 510
+    ErrUnimplementedCommand = errors.New("unimplemented command")            // 511
+          ErrSyntaxArgument = errors.New("syntax error in command argument") // 512
+    ErrUnrecognizedArgument = errors.New("unrecognized command argument")    // 513
+            ErrAuthRequired = errors.New("authentication required")          // 514
+       ErrBadAuthentication = errors.New("bad authentication")               // 515
+          ErrUnspecifiedTor = errors.New("unspecified tor error")            // 550
+                ErrInternal = errors.New("internal error")                   // 551
+      ErrUnrecognizedEntity = errors.New("unrecognized entity")              // 552
+           ErrInvalidConfig = errors.New("invalid configuration value")      // 553
+       ErrInvalidDescriptor = errors.New("invalid descriptor")               // 554
+         ErrUnmanagedEntity = errors.New("unmanaged entity")                 // 555
+)
+
+var statusErrors = map[int]error{
+    451: ErrResourceExhausted,
+    500: ErrSyntaxProtocol,
+    510: ErrUnrecognizedCommand,
+    511: ErrUnimplementedCommand,
+    512: ErrSyntaxArgument,
+    513: ErrUnrecognizedArgument,
+    514: ErrAuthRequired,
+    515: ErrBadAuthentication,
+    550: ErrUnspecifiedTor,
+    551: ErrInternal,
+    552: ErrUnrecognizedEntity,
+    553: ErrInvalidConfig,
+    554: ErrInvalidDescriptor,
+    555: ErrUnmanagedEntity,
+}
+
+// The ReplyError type is returned by command methods when Tor replies with a
+// negative completion reply. It matches the error classes above, and the error
+// for its specific status code, with errors.Is.
+type ReplyError struct {
+    Status  int
+    Text    string
+    Request ControlRequest
+}
+
+func (e *ReplyError) Error() string {
+    return fmt.Sprintf("%d %s", e.Status, e.Text)
+}
+
+func (e *ReplyError) Is(target error) bool {
+    switch target {
+    case ErrTemporary:
+        return e.Status / 100 == 4
+    case ErrPermanent:
+        return e.Status / 100 == 5
+    case ErrSyntax:
+        return e.Status / 10 % 10 == 0
+    case ErrProtocol:
+        return e.Status / 10 % 10 == 1
+    case ErrTor:
+        return e.Status / 10 % 10 == 5
+    }
+    return statusErrors[e.Status] == target
+}
+
+// Returns true if the command might succeed if reattempted later.
+func (e *ReplyError) Temporary() bool {
+    return e.Status / 100 == 4
+}
+
+// The TimeoutAction type describes how a Controller recovers the connection
+// when a request's context ends before its reply arrives.
+type TimeoutAction int
+
+const (
+    // Keep the request queued, so its late reply is consumed and discarded
+    // rather than handed to the next request.
+    TIMEOUT_DISCARD_REPLY TimeoutAction = iota
+
+    // Treat the connection as broken and tear it down, failing all other
+    // pending requests.
+    TIMEOUT_CLOSE_CONNECTION
+)
+
+func (a TimeoutAction) String() string {
+    switch a {
+    case TIMEOUT_DISCARD_REPLY:
+        return "late reply will be discarded"
+    case TIMEOUT_CLOSE_CONNECTION:
+        return "connection closed"
+    }
+    return fmt.Sprintf("TimeoutAction(%d)", int(a))
+}
+
+// The RequestTimeoutError type is returned when a request's context ends
+// before its reply arrives. Action reports which recovery path was taken, and
+// Err holds the context error.
+type RequestTimeoutError struct {
+    Request ControlRequest
+    Action  TimeoutAction
+    Err     error
+}
+
+func (e *RequestTimeoutError) Error() string {
+    return fmt.Sprintf("Failed waiting for reply: %v (%s)", e.Err, e.Action)
+}
+
+func (e *RequestTimeoutError) Unwrap() error { return e.Err }
+
+// Returns true if the request deadline was exceeded, rather than the request
+// being cancelled.
+func (e *RequestTimeoutError) Timeout() bool {
+    return e.Err == context.DeadlineExceeded
+}
+
+// The ProtocolError type reports a violation of the control protocol found in
+// a received Line, see Parser.Errors().
+type ProtocolError struct {
+    Line   string
+    Reason string
+}
+
+func (e *ProtocolError) Error() string {
+    return fmt.Sprintf("Protocol error, %s: %q", e.Reason, redactSecretKeys(e.Line))
+}
+
+// The AuthAttempt type records a failed attempt to authenticate with Method.
+type AuthAttempt struct {
+    Method string
+    Err    error
+}
+
+// The AuthError type is returned when a Controller fails to authenticate. It
+// lists the methods advertised by Tor and each attempt made, in order.
+type AuthError struct {
+    Advertised []string
+    Attempts   []AuthAttempt
+}
+
+func (e *AuthError) Error() string {
+    if len(e.Attempts) == 0 {
+        return fmt.Sprintf("Failed to find compatible authentication method, advertised: %s",
+                           strings.Join(e.Advertised, ","))
+    }
+
+    attempts := make([]string, 0, len(e.Attempts))
+    for _, v := range e.Attempts {
+        attempts = append(attempts, fmt.Sprintf("%s: %v", v.Method, v.Err))
+    }
+    return "Authentication failed (" + strings.Join(attempts, "; ") + ")"
+}
+
+// Returns the error of each attempt, so errors.Is and errors.As inspect them.
+func (e *AuthError) Unwrap() []error {
+    results := make([]error, 0, len(e.Attempts))
+    for _, v := range e.Attempts {
+        results = append(results, v.Err)
+    }
+    return results
+}
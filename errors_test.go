@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+
+package torc
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestReplyErrorIs(t *testing.T) {
+    classes := []error{ErrTemporary, ErrPermanent, ErrSyntax, ErrProtocol, ErrTor}
+
+    // The class errors each status code matches, besides its specific error.
+    tests := []struct {
+        status int
+        want   []error
+    }{
+        {451, []error{ErrTemporary, ErrTor}},
+        {500, []error{ErrPermanent, ErrSyntax}},
+        {510, []error{ErrPermanent, ErrProtocol}},
+        {511, []error{ErrPermanent, ErrProtocol}},
+        {512, []error{ErrPermanent, ErrProtocol}},
+        {513, []error{ErrPermanent, ErrProtocol}},
+        {514, []error{ErrPermanent, ErrProtocol}},
+        {515, []error{ErrPermanent, ErrProtocol}},
+        {550, []error{ErrPermanent, ErrTor}},
+        {551, []error{ErrPermanent, ErrTor}},
+        {552, []error{ErrPermanent, ErrTor}},
+        {553, []error{ErrPermanent, ErrTor}},
+        {554, []error{ErrPermanent, ErrTor}},
+        {555, []error{ErrPermanent, ErrTor}},
+        {401, []error{ErrTemporary, ErrSyntax}},
+        {412, []error{ErrTemporary, ErrProtocol}},
+        {520, []error{ErrPermanent}},
+    }
+
+    for _, v := range tests {
+        t.Run(fmt.Sprint(v.status), func(t *testing.T) {
+            var e error = fmt.Errorf("wrapped: %w", &ReplyError{Status: v.status, Text: "failed"})
+
+            for _, class := range classes {
+                want := false
+                for _, w := range v.want { want = want || w == class }
+                if errors.Is(e, class) != want { t.Errorf("Expected errors.Is(%v) %t", class, want) }
+            }
+
+            for status, specific := range statusErrors {
+                if want := status == v.status; errors.Is(e, specific) != want {
+                    t.Errorf("Expected errors.Is(%v) %t", specific, want)
+                }
+            }
+
+            var reply *ReplyError
+            if !errors.As(e, &reply) { t.Fatal("Expected a *ReplyError") }
+            if temporary := v.status / 100 == 4; reply.Temporary() != temporary { t.Errorf("Expected Temporary() %t", temporary) }
+        })
+    }
+}
+
+func TestGetInfoUnrecognizedKey(t *testing.T) {
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETINFO bogus") { return []string{"552 Unrecognized key \"bogus\""} }
+        return fakeReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    _, e := c.GetInfo([]string{"bogus"})
+    if !errors.Is(e, ErrUnrecognizedEntity) { t.Errorf("Expected ErrUnrecognizedEntity, got %v", e) }
+    if !errors.Is(e, ErrPermanent) || !errors.Is(e, ErrTor) { t.Errorf("Expected a permanent Tor error, got %v", e) }
+    if errors.Is(e, ErrTemporary) || errors.Is(e, ErrProtocol) { t.Errorf("Unexpected error class for %v", e) }
+}
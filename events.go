@@ -0,0 +1,472 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Tor control protocol asynchronous event names, use these when subscribing to
+// events with the SetEvents command method, or when registering handlers with
+// AddEventHandler.
+const (
+                 EVENT_CIRC = "CIRC"
+           EVENT_CIRC_MINOR = "CIRC_MINOR"
+               EVENT_STREAM = "STREAM"
+               EVENT_ORCONN = "ORCONN"
+                   EVENT_BW = "BW"
+              EVENT_CIRC_BW = "CIRC_BW"
+            EVENT_STREAM_BW = "STREAM_BW"
+                EVENT_DEBUG = "DEBUG"
+                 EVENT_INFO = "INFO"
+               EVENT_NOTICE = "NOTICE"
+                 EVENT_WARN = "WARN"
+                  EVENT_ERR = "ERR"
+              EVENT_NEWDESC = "NEWDESC"
+              EVENT_ADDRMAP = "ADDRMAP"
+       EVENT_STATUS_GENERAL = "STATUS_GENERAL"
+        EVENT_STATUS_CLIENT = "STATUS_CLIENT"
+        EVENT_STATUS_SERVER = "STATUS_SERVER"
+                EVENT_GUARD = "GUARD"
+                   EVENT_NS = "NS"
+         EVENT_NEWCONSENSUS = "NEWCONSENSUS"
+               EVENT_SIGNAL = "SIGNAL"
+         EVENT_CONF_CHANGED = "CONF_CHANGED"
+              EVENT_HS_DESC = "HS_DESC"
+      EVENT_HS_DESC_CONTENT = "HS_DESC_CONTENT"
+     EVENT_NETWORK_LIVENESS = "NETWORK_LIVENESS"
+)
+
+// The Event interface describes the interface implemented by all decoded
+// asynchronous event types.
+type Event interface {
+    EventType() string
+    Raw() ResponseBuffer
+}
+
+// The BaseEvent type is the base type of all event types.
+type BaseEvent struct {
+    Type   string
+    Buffer ResponseBuffer
+}
+
+// Returns the event name, e.g. "CIRC".
+func (e *BaseEvent) EventType() string { return e.Type }
+
+// Returns the reply the event was decoded from.
+func (e *BaseEvent) Raw() ResponseBuffer { return e.Buffer }
+
+// The UnknownEvent type is returned by DecodeEvent for events that have no
+// typed representation, or that failed to decode.
+type UnknownEvent struct {
+    *BaseEvent
+
+    // Received reply lines, in order of arrival.
+    Lines []string
+}
+
+// The CircuitEvent type represents a CIRC event.
+type CircuitEvent struct {
+    *BaseEvent
+
+    CircuitID     string
+    Status        string
+    Path          []string
+    BuildFlags    []string
+    Purpose       string
+    HSState       string
+    RendQuery     string
+    TimeCreated   time.Time
+    Reason        string
+    RemoteReason  string
+    SocksUsername string
+    SocksPassword Secret
+}
+
+// The StreamEvent type represents a STREAM event.
+type StreamEvent struct {
+    *BaseEvent
+
+    StreamID     string
+    Status       string
+    CircuitID    string
+    Target       string
+    Reason       string
+    RemoteReason string
+    Source       string
+    SourceAddr   string
+    Purpose      string
+}
+
+// The ORConnEvent type represents an ORCONN event.
+type ORConnEvent struct {
+    *BaseEvent
+
+    Target      string
+    Status      string
+    Reason      string
+    NumCircuits int
+    ConnID      string
+}
+
+// The BandwidthEvent type represents a BW event.
+type BandwidthEvent struct {
+    *BaseEvent
+
+    BytesRead    uint64
+    BytesWritten uint64
+}
+
+// The CircuitBandwidthEvent type represents a CIRC_BW event.
+type CircuitBandwidthEvent struct {
+    *BaseEvent
+
+    CircuitID    string
+    BytesRead    uint64
+    BytesWritten uint64
+}
+
+// The StreamBandwidthEvent type represents a STREAM_BW event.
+type StreamBandwidthEvent struct {
+    *BaseEvent
+
+    StreamID     string
+    BytesWritten uint64
+    BytesRead    uint64
+}
+
+// The LogEvent type represents DEBUG, INFO, NOTICE, WARN and ERR events, the
+// severity is reported by EventType().
+type LogEvent struct {
+    *BaseEvent
+
+    Message string
+}
+
+// The NewDescEvent type represents a NEWDESC event.
+type NewDescEvent struct {
+    *BaseEvent
+
+    Servers []string
+}
+
+// The AddrMapEvent type represents an ADDRMAP event. Expiry is the zero time
+// when the mapping never expires.
+type AddrMapEvent struct {
+    *BaseEvent
+
+    Address    string
+    NewAddress string
+    Expiry     time.Time
+    Error      string
+    Cached     bool
+    StreamID   string
+}
+
+// The StatusEvent type represents STATUS_GENERAL, STATUS_CLIENT and
+// STATUS_SERVER events, the status type is reported by EventType().
+type StatusEvent struct {
+    *BaseEvent
+
+    Severity  string
+    Action    string
+    Arguments map[string]string
+}
+
+// The GuardEvent type represents a GUARD event.
+type GuardEvent struct {
+    *BaseEvent
+
+    GuardType string
+    Name      string
+    Status    string
+}
+
+// The SignalEvent type represents a SIGNAL event.
+type SignalEvent struct {
+    *BaseEvent
+
+    Signal Signal
+}
+
+// The ConfChangedEvent type represents a CONF_CHANGED event. Options reset to
+// their defaults are reported with IsDefault set.
+type ConfChangedEvent struct {
+    *BaseEvent
+
+    Changed Config
+}
+
+// The HSDescEvent type represents an HS_DESC event.
+type HSDescEvent struct {
+    *BaseEvent
+
+    Action       string
+    Address      string
+    AuthType     string
+    HSDir        string
+    DescriptorID string
+    Reason       string
+    Replica      string
+    HSDirIndex   string
+}
+
+// The NetworkLivenessEvent type represents a NETWORK_LIVENESS event.
+type NetworkLivenessEvent struct {
+    *BaseEvent
+
+    Status string
+}
+
+// Returns true if Tor believes the network is reachable.
+func (e *NetworkLivenessEvent) IsUp() bool { return e.Status == "UP" }
+
+type eventDecoder func(*BaseEvent, []string, map[string]string) (Event, error)
+
+var eventDecoders = map[string]eventDecoder{
+                 EVENT_CIRC: decodeCircuitEvent,
+               EVENT_STREAM: decodeStreamEvent,
+               EVENT_ORCONN: decodeORConnEvent,
+                   EVENT_BW: decodeBandwidthEvent,
+              EVENT_CIRC_BW: decodeCircuitBandwidthEvent,
+            EVENT_STREAM_BW: decodeStreamBandwidthEvent,
+                EVENT_DEBUG: decodeLogEvent,
+                 EVENT_INFO: decodeLogEvent,
+               EVENT_NOTICE: decodeLogEvent,
+                 EVENT_WARN: decodeLogEvent,
+                  EVENT_ERR: decodeLogEvent,
+              EVENT_NEWDESC: decodeNewDescEvent,
+              EVENT_ADDRMAP: decodeAddrMapEvent,
+       EVENT_STATUS_GENERAL: decodeStatusEvent,
+        EVENT_STATUS_CLIENT: decodeStatusEvent,
+        EVENT_STATUS_SERVER: decodeStatusEvent,
+                EVENT_GUARD: decodeGuardEvent,
+               EVENT_SIGNAL: decodeSignalEvent,
+         EVENT_CONF_CHANGED: decodeConfChangedEvent,
+              EVENT_HS_DESC: decodeHSDescEvent,
+     EVENT_NETWORK_LIVENESS: decodeNetworkLivenessEvent,
+}
+
+// Decodes an asynchronous event reply into its typed representation. Events
+// without a typed representation are returned as an UnknownEvent. When a known
+// event fails to decode, an UnknownEvent is returned along with the error so
+// the raw lines are never lost.
+func DecodeEvent(buff ResponseBuffer) (Event, error) {
+    base := &BaseEvent{Type: buff.EventName(), Buffer: buff}
+    unknown := &UnknownEvent{base, buff.RawLines}
+
+    if !buff.IsAsync() {
+        return unknown, fmt.Errorf("Not an asynchronous event reply: %s", buff.EndReplyLine)
+    }
+
+    decoder, ok := eventDecoders[base.Type]
+    if !ok { return unknown, nil }
+
+    text := strings.TrimPrefix(buff.firstLineText(), base.Type)
+    tokens := ParseReplyArgs(text)
+    args, kwargs := tokens.Positional(), tokens.Map()
+
+    event, e := decoder(base, args, kwargs)
+    if e != nil {
+        return unknown, fmt.Errorf("Failed to decode %s event: %v", base.Type, e)
+    }
+    return event, nil
+}
+
+// Event decoders --------------------------------------------------------------
+func decodeCircuitEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 2 { return nil, fmt.Errorf("Missing arguments") }
+
+    e := &CircuitEvent{BaseEvent: b, CircuitID: args[0], Status: args[1]}
+    if len(args) > 2 { e.Path = strings.Split(args[2], ",") }
+    if v, ok := kwargs["BUILD_FLAGS"]; ok { e.BuildFlags = strings.Split(v, ",") }
+
+    e.Purpose       = kwargs["PURPOSE"]
+    e.HSState       = kwargs["HS_STATE"]
+    e.RendQuery     = kwargs["REND_QUERY"]
+    e.Reason        = kwargs["REASON"]
+    e.RemoteReason  = kwargs["REMOTE_REASON"]
+    e.SocksUsername = kwargs["SOCKS_USERNAME"]
+    e.SocksPassword = Secret(kwargs["SOCKS_PASSWORD"])
+
+    if v, ok := kwargs["TIME_CREATED"]; ok {
+        t, err := time.ParseInLocation("2006-01-02T15:04:05.999999", v, time.UTC)
+        if err != nil { return nil, err }
+        e.TimeCreated = t
+    }
+
+    return e, nil
+}
+
+func decodeStreamEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 4 { return nil, fmt.Errorf("Missing arguments") }
+
+    return &StreamEvent{
+        BaseEvent:    b,
+        StreamID:     args[0],
+        Status:       args[1],
+        CircuitID:    args[2],
+        Target:       args[3],
+        Reason:       kwargs["REASON"],
+        RemoteReason: kwargs["REMOTE_REASON"],
+        Source:       kwargs["SOURCE"],
+        SourceAddr:   kwargs["SOURCE_ADDR"],
+        Purpose:      kwargs["PURPOSE"],
+    }, nil
+}
+
+func decodeORConnEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 2 { return nil, fmt.Errorf("Missing arguments") }
+
+    e := &ORConnEvent{BaseEvent: b, Target: args[0], Status: args[1]}
+    e.Reason = kwargs["REASON"]
+    e.ConnID = kwargs["ID"]
+
+    if v, ok := kwargs["NCIRCS"]; ok {
+        i, err := strconv.Atoi(v)
+        if err != nil { return nil, err }
+        e.NumCircuits = i
+    }
+
+    return e, nil
+}
+
+func decodeBandwidthEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 2 { return nil, fmt.Errorf("Missing arguments") }
+
+    read, e := strconv.ParseUint(args[0], 10, 64)
+    if e != nil { return nil, e }
+    written, e := strconv.ParseUint(args[1], 10, 64)
+    if e != nil { return nil, e }
+
+    return &BandwidthEvent{b, read, written}, nil
+}
+
+func decodeCircuitBandwidthEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    read, e := strconv.ParseUint(kwargs["READ"], 10, 64)
+    if e != nil { return nil, e }
+    written, e := strconv.ParseUint(kwargs["WRITTEN"], 10, 64)
+    if e != nil { return nil, e }
+
+    return &CircuitBandwidthEvent{b, kwargs["ID"], read, written}, nil
+}
+
+func decodeStreamBandwidthEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 3 { return nil, fmt.Errorf("Missing arguments") }
+
+    written, e := strconv.ParseUint(args[1], 10, 64)
+    if e != nil { return nil, e }
+    read, e := strconv.ParseUint(args[2], 10, 64)
+    if e != nil { return nil, e }
+
+    return &StreamBandwidthEvent{b, args[0], written, read}, nil
+}
+
+func decodeLogEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    // Multi-line log messages are sent as a data reply.
+    if len(b.Buffer.DataReplyLines) > 0 {
+        data := b.Buffer.DataReplyLines[0]
+        return &LogEvent{b, strings.Join([]string(data)[1:], "\n")}, nil
+    }
+
+    text := strings.TrimPrefix(b.Buffer.firstLineText(), b.Type)
+    return &LogEvent{b, strings.TrimPrefix(text, " ")}, nil
+}
+
+func decodeNewDescEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    return &NewDescEvent{b, args}, nil
+}
+
+func decodeAddrMapEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 3 { return nil, fmt.Errorf("Missing arguments") }
+
+    e := &AddrMapEvent{BaseEvent: b, Address: args[0], NewAddress: args[1]}
+    e.Error    = kwargs["error"]
+    e.Cached   = kwargs["CACHED"] == "YES"
+    e.StreamID = kwargs["STREAMID"]
+
+    // Prefer the UTC expiry over the local time one when available.
+    var err error
+    if v, ok := kwargs["EXPIRES"]; ok {
+        e.Expiry, err = time.ParseInLocation("2006-01-02 15:04:05", v, time.UTC)
+    } else if args[2] != "NEVER" {
+        e.Expiry, err = time.ParseInLocation("2006-01-02 15:04:05", args[2], time.Local)
+    }
+    if err != nil { return nil, err }
+
+    return e, nil
+}
+
+func decodeStatusEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 2 { return nil, fmt.Errorf("Missing arguments") }
+    return &StatusEvent{b, args[0], args[1], kwargs}, nil
+}
+
+func decodeGuardEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 3 { return nil, fmt.Errorf("Missing arguments") }
+    return &GuardEvent{b, args[0], args[1], args[2]}, nil
+}
+
+func decodeSignalEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 1 { return nil, fmt.Errorf("Missing arguments") }
+    return &SignalEvent{b, Signal(args[0])}, nil
+}
+
+func decodeConfChangedEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    e := &ConfChangedEvent{b, make(Config, 0)}
+
+    // The first mid reply line carries the event name, the rest the options.
+    for _, v := range b.Buffer.MidReplyLines {
+        if v.Text() == EVENT_CONF_CHANGED { continue }
+        e.Changed = append(e.Changed, parseConfigEntry(v.Text()))
+    }
+
+    return e, nil
+}
+
+func decodeHSDescEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 4 { return nil, fmt.Errorf("Missing arguments") }
+
+    e := &HSDescEvent{
+        BaseEvent:  b,
+        Action:     args[0],
+        Address:    args[1],
+        AuthType:   args[2],
+        HSDir:      args[3],
+        Reason:     kwargs["REASON"],
+        Replica:    kwargs["REPLICA"],
+        HSDirIndex: kwargs["HSDIR_INDEX"],
+    }
+    if len(args) > 4 { e.DescriptorID = args[4] }
+
+    return e, nil
+}
+
+func decodeNetworkLivenessEvent(b *BaseEvent, args []string, kwargs map[string]string) (Event, error) {
+    if len(args) < 1 { return nil, fmt.Errorf("Missing arguments") }
+    return &NetworkLivenessEvent{b, args[0]}, nil
+}
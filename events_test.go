@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+
+package torc
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Parses raw, lines separated by "\n", and decodes the single event in it.
+func decodeRaw(t *testing.T, raw string) (Event, error) {
+    t.Helper()
+    _, events, errs, _ := parseAll(strings.NewReader(strings.ReplaceAll(raw, "\n", "\r\n")))
+    if len(errs) != 0 { t.Fatalf("Parser errors: %v", errs) }
+    if len(events) != 1 { t.Fatalf("Expected 1 event, got %d", len(events)) }
+    return DecodeEvent(events[0])
+}
+
+func TestDecodeEvent(t *testing.T) {
+    tests := []struct {
+        name string
+        raw  string
+        want Event
+    }{
+        {"CIRC", "650 CIRC 5 BUILT $A~a,$B~b BUILD_FLAGS=IS_INTERNAL,NEED_CAPACITY PURPOSE=HS_SERVICE_REND HS_STATE=HSSR_JOINED REND_QUERY=abc TIME_CREATED=2024-01-02T03:04:05.123456 SOCKS_USERNAME=\"u\" SOCKS_PASSWORD=\"p\"\n",
+            &CircuitEvent{CircuitID: "5", Status: "BUILT", Path: []string{"$A~a", "$B~b"}, BuildFlags: []string{"IS_INTERNAL", "NEED_CAPACITY"},
+                Purpose: "HS_SERVICE_REND", HSState: "HSSR_JOINED", RendQuery: "abc", TimeCreated: time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC),
+                SocksUsername: "u", SocksPassword: "p"}},
+        {"CIRC without path", "650 CIRC 6 LAUNCHED REASON=FINISHED REMOTE_REASON=DESTROYED\n",
+            &CircuitEvent{CircuitID: "6", Status: "LAUNCHED", Reason: "FINISHED", RemoteReason: "DESTROYED"}},
+        {"STREAM", "650 STREAM 9 NEW 0 example.onion:80 SOURCE_ADDR=127.0.0.1:5000 PURPOSE=USER\n",
+            &StreamEvent{StreamID: "9", Status: "NEW", CircuitID: "0", Target: "example.onion:80", SourceAddr: "127.0.0.1:5000", Purpose: "USER"}},
+        {"ORCONN", "650 ORCONN $A~a CLOSED REASON=DONE NCIRCS=3 ID=12\n",
+            &ORConnEvent{Target: "$A~a", Status: "CLOSED", Reason: "DONE", NumCircuits: 3, ConnID: "12"}},
+        {"BW", "650 BW 100 200\n", &BandwidthEvent{BytesRead: 100, BytesWritten: 200}},
+        {"CIRC_BW", "650 CIRC_BW ID=5 READ=100 WRITTEN=200\n", &CircuitBandwidthEvent{CircuitID: "5", BytesRead: 100, BytesWritten: 200}},
+        {"STREAM_BW", "650 STREAM_BW 9 100 200 2024-01-02T03:04:05.000000\n", &StreamBandwidthEvent{StreamID: "9", BytesWritten: 100, BytesRead: 200}},
+        {"NOTICE", "650 NOTICE Bootstrapped 100% (done): Done\n", &LogEvent{Message: "Bootstrapped 100% (done): Done"}},
+        {"WARN multi-line", "650+WARN\nfirst line\n..dotted line\n.\n650 OK\n", &LogEvent{Message: "first line\n.dotted line"}},
+        {"NEWDESC", "650 NEWDESC $A~a $B~b\n", &NewDescEvent{Servers: []string{"$A~a", "$B~b"}}},
+        {"ADDRMAP quoted expiry", "650 ADDRMAP example.com 192.0.2.1 \"2024-01-02 03:04:05\" STREAMID=9\n",
+            &AddrMapEvent{Address: "example.com", NewAddress: "192.0.2.1", Expiry: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local), StreamID: "9"}},
+        {"ADDRMAP EXPIRES", "650 ADDRMAP example.com 192.0.2.1 \"2024-01-02 03:04:05\" EXPIRES=\"2024-01-02 08:04:05\" CACHED=\"YES\"\n",
+            &AddrMapEvent{Address: "example.com", NewAddress: "192.0.2.1", Expiry: time.Date(2024, 1, 2, 8, 4, 5, 0, time.UTC), Cached: true}},
+        {"ADDRMAP never", "650 ADDRMAP example.com <error> NEVER error=yes CACHED=\"NO\"\n",
+            &AddrMapEvent{Address: "example.com", NewAddress: "<error>", Error: "yes"}},
+        {"STATUS_CLIENT", "650 STATUS_CLIENT NOTICE CIRCUIT_ESTABLISHED\n",
+            &StatusEvent{Severity: "NOTICE", Action: "CIRCUIT_ESTABLISHED", Arguments: map[string]string{}}},
+        {"STATUS_CLIENT arguments", "650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY=\"Done\"\n",
+            &StatusEvent{Severity: "NOTICE", Action: "BOOTSTRAP", Arguments: map[string]string{"PROGRESS": "100", "TAG": "done", "SUMMARY": "Done"}}},
+        {"GUARD", "650 GUARD ENTRY $A~a GOOD\n", &GuardEvent{GuardType: "ENTRY", Name: "$A~a", Status: "GOOD"}},
+        {"SIGNAL", "650 SIGNAL NEWNYM\n", &SignalEvent{Signal: SIGNAL_NEWNYM}},
+        {"CONF_CHANGED", "650-CONF_CHANGED\n650-SocksPort=9050\n650-SocksPort=9150\n650-ExitPolicy\n650 OK\n",
+            &ConfChangedEvent{Changed: Config{{Key: "SocksPort", Value: "9050"}, {Key: "SocksPort", Value: "9150"}, {Key: "ExitPolicy", IsDefault: true}}}},
+        {"HS_DESC", "650 HS_DESC RECEIVED abc NO_AUTH $A~a desc REPLICA=1 HSDIR_INDEX=ff\n",
+            &HSDescEvent{Action: "RECEIVED", Address: "abc", AuthType: "NO_AUTH", HSDir: "$A~a", DescriptorID: "desc", Replica: "1", HSDirIndex: "ff"}},
+        {"HS_DESC failed", "650 HS_DESC FAILED abc NO_AUTH UNKNOWN REASON=NOT_FOUND\n",
+            &HSDescEvent{Action: "FAILED", Address: "abc", AuthType: "NO_AUTH", HSDir: "UNKNOWN", Reason: "NOT_FOUND"}},
+        {"NETWORK_LIVENESS", "650 NETWORK_LIVENESS UP\n", &NetworkLivenessEvent{Status: "UP"}},
+    }
+
+    for _, v := range tests {
+        t.Run(v.name, func(t *testing.T) {
+            event, e := decodeRaw(t, v.raw)
+            if e != nil { t.Fatal(e) }
+            if reflect.TypeOf(event) != reflect.TypeOf(v.want) { t.Fatalf("Expected %T, got %T", v.want, event) }
+            if event.Raw().EventName() != event.EventType() { t.Errorf("Expected type %s, got %s", event.Raw().EventName(), event.EventType()) }
+
+            // Compare the decoded fields alone.
+            reflect.ValueOf(event).Elem().FieldByName("BaseEvent").SetZero()
+            if !reflect.DeepEqual(event, v.want) { t.Errorf("Expected %+v, got %+v", v.want, event) }
+        })
+    }
+}
+
+func TestDecodeEventFallback(t *testing.T) {
+    tests := []struct {
+        name    string
+        raw     string
+        failing bool
+    }{
+        {"unsupported event", "650 CELL_STATS ID=5\n", false},
+        {"bad BW count", "650 BW abc 200\n", true},
+        {"missing arguments", "650 CIRC 5\n", true},
+        {"bad TIME_CREATED", "650 CIRC 5 BUILT TIME_CREATED=yesterday\n", true},
+        {"bad ADDRMAP expiry", "650 ADDRMAP example.com 192.0.2.1 \"soon\"\n", true},
+        {"bad NCIRCS", "650 ORCONN $A~a CLOSED NCIRCS=many\n", true},
+    }
+
+    for _, v := range tests {
+        t.Run(v.name, func(t *testing.T) {
+            event, e := decodeRaw(t, v.raw)
+            if (e != nil) != v.failing { t.Fatalf("Expected failure %t, got %v", v.failing, e) }
+
+            unknown, ok := event.(*UnknownEvent)
+            if !ok { t.Fatalf("Expected *UnknownEvent, got %T", event) }
+            if want := strings.TrimSuffix(v.raw, "\n"); len(unknown.Lines) != 1 || unknown.Lines[0] != want {
+                t.Errorf("Expected lines [%s], got %q", want, unknown.Lines)
+            }
+        })
+    }
+}
+
+func TestDecodeEventNotAsync(t *testing.T) {
+    event, e := DecodeEvent(ResponseBuffer{EndReplyLine: "250 OK", RawLines: []string{"250 OK"}})
+    if e == nil { t.Fatal("Expected an error decoding a synchronous reply") }
+    if _, ok := event.(*UnknownEvent); !ok { t.Fatalf("Expected *UnknownEvent, got %T", event) }
+}
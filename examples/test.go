@@ -26,6 +26,7 @@ package main
 import (
     "flag"
     "fmt"
+    "log/slog"
     "os"
 
     "github.com/tswindell/go-torc"
@@ -42,50 +43,59 @@ func NotImplemented() error { return fmt.Errorf("Not implemented yet!") }
 func main() {
     flag.Parse()
 
-    torc.LogInfo("Initializing control socket service handler.")
+    logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+        Level: slog.LevelDebug,
+    }))
+
+    logger.Info("Initializing control socket service handler.")
     ctrl := torc.NewController("tcp", *hostport)
+    ctrl.Logger = logger
     defer ctrl.Close()
 
-    torc.LogInfo("Attempting to connect to Tor control socket.")
+    logger.Info("Attempting to connect to Tor control socket.")
     if ctrl.Connect() != nil {
         os.Exit(1)
     }
 
     p, e := ctrl.ProtocolInfo()
     if e != nil || !p.IsSuccess() {
-        torc.LogInfo("Failed to send protocol info request: %v", e)
+        logger.Error("Failed to send protocol info request.", "error", e)
         os.Exit(1)
     }
-    torc.LogInfo("PROTOCOLINFO=%d", p.Protocol())
-    torc.LogInfo("AUTH-METHODS=%v", p.AuthMethods())
-    torc.LogInfo("AUTH-COOKIEFILE=%v", p.AuthCookieFile())
-    torc.LogInfo("VERSIONS Tor=%v", p.Version()["Tor"])
+    protocol, _ := p.Protocol()
+    cookieFile, _ := p.AuthCookieFile()
+    logger.Info("PROTOCOLINFO", "protocol", protocol,
+                                "auth_methods", p.AuthMethods(),
+                                "auth_cookiefile", cookieFile,
+                                "tor_version", p.Version()["Tor"])
 
     r, e := ctrl.GetInfo([]string{"version", "info/names", "events/names"})
     if e != nil || !r.IsSuccess() {
-        torc.LogInfo("Failed to send get info request: %v", e)
+        logger.Error("Failed to send get info request.", "error", e)
         os.Exit(1)
     }
 
-    torc.LogInfo("  Tor VERSION=%s", r.ValueOf("version"))
-    torc.LogInfo("  info/names=%s", r.ValueOf("info/names"))
-    torc.LogInfo("  events/names=%s", r.ValueOf("events/names"))
+    for _, k := range []string{"version", "info/names", "events/names"} {
+        v, _ := r.ValueOf(k)
+        logger.Info("GETINFO", "key", k, "value", v)
+    }
 
     c, e := ctrl.GetConf([]string{"SocksPort", "ControlPort", "CookieAuthentication"})
     if e != nil || !c.IsSuccess() {
-        torc.LogInfo("Failed to send get configuration request: %v", e)
+        logger.Error("Failed to send get configuration request.", "error", e)
         os.Exit(1)
     }
-    torc.LogInfo("%v", c.ValueAll())
+    logger.Info("GETCONF", "config", c.ValueAll())
 
-    d, e := ctrl.SetConf(map[string][]string{
-        "SocksPort": []string{"127.0.0.1:9050", "10.0.0.1:9050"},
-    })
+    d, e := ctrl.SetConf(torc.NewConfig(
+        "SocksPort", "127.0.0.1:9050",
+        "SocksPort", "10.0.0.1:9050",
+    ))
     if e != nil {
-        torc.LogInfo("Failed to send set configuration request: %v", e)
+        logger.Error("Failed to send set configuration request.", "error", e)
         os.Exit(1)
     }
-    torc.LogInfo("%d - %s", d.Status(), d.StatusText())
+    logger.Info("SETCONF", "status", d.Status(), "text", d.StatusText())
 
     o, e := ctrl.AddOnion(torc.ONION_KEY_TYPE_NEW,
                           torc.ONION_KEY_BLOB_BEST,
@@ -98,11 +108,12 @@ func main() {
                               "80,127.0.0.1:9600",
                           })
     if e != nil {
-        torc.LogError("Failed to send add onion request: %v", e)
+        logger.Error("Failed to send add onion request.", "error", e)
         os.Exit(1)
     }
-    torc.LogInfo("  ServiceID: %s", o.ServiceId())
-    torc.LogInfo(" ServiceKey: %s", o.PrivateKey())
+    serviceId, _ := o.ServiceId()
+    serviceKey, _ := o.PrivateKey()
+    logger.Info("ADD_ONION", "service_id", serviceId, "service_key", serviceKey)
 
     os.Exit(0)
 }
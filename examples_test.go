@@ -2,10 +2,12 @@ package torc_test
 
 import (
     "fmt"
+
+    "github.com/tswindell/go-torc"
 )
 
 func Example() {
-    controller := NewController("tcp", "127.0.0.1:9051")
+    controller := torc.NewController("tcp", "127.0.0.1:9051")
 
     if e := controller.Connect(); e != nil {
         // Handle error
@@ -16,11 +18,11 @@ func Example() {
         // Handle error
     }
 
-    fmt.Println(repsonse.ValueOf("version"))
+    fmt.Println(response.ValueOf("version"))
 }
 
 func ExampleNewController() {
-    controller := NewController("tcp", "127.0.0.1:9051")
+    controller := torc.NewController("tcp", "127.0.0.1:9051")
 
     if e := controller.Connect(); e != nil {
         // Handle error
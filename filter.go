@@ -0,0 +1,469 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Returned, wrapped in a *FilterError, when a filter expression can't be
+// parsed.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// The FilterError type describes a filter expression rejected by ParseFilter,
+// Pos is the byte offset the problem was found at. It matches ErrInvalidFilter
+// with errors.Is.
+type FilterError struct {
+    Expr   string
+    Pos    int
+    Reason string
+}
+
+func (e *FilterError) Error() string {
+    return fmt.Sprintf("Invalid filter %q at offset %d: %s", e.Expr, e.Pos, e.Reason)
+}
+
+func (e *FilterError) Unwrap() error { return ErrInvalidFilter }
+
+// The Filter type is a predicate over decoded events, see
+// SubscribeOptions.Filter. Filters are built with ParseFilter, or with Field()
+// and the combinators below.
+type Filter func(Event) bool
+
+// Returns a Filter matching events matched by f and all of others.
+func (f Filter) And(others ...Filter) Filter {
+    return AllOf(append([]Filter{f}, others...)...)
+}
+
+// Returns a Filter matching events matched by f or any of others.
+func (f Filter) Or(others ...Filter) Filter {
+    return AnyOf(append([]Filter{f}, others...)...)
+}
+
+// Returns a Filter matching events matched by every one of filters.
+func AllOf(filters ...Filter) Filter {
+    return func(e Event) bool {
+        for _, f := range filters {
+            if !f(e) { return false }
+        }
+        return true
+    }
+}
+
+// Returns a Filter matching events matched by any one of filters.
+func AnyOf(filters ...Filter) Filter {
+    return func(e Event) bool {
+        for _, f := range filters {
+            if f(e) { return true }
+        }
+        return false
+    }
+}
+
+// Returns a Filter matching events not matched by f.
+func Not(f Filter) Filter {
+    return func(e Event) bool { return !f(e) }
+}
+
+// Returns a Filter matching events of any of the given types, e.g. "CIRC".
+func EventIs(types ...string) Filter {
+    return func(e Event) bool { return __contains(types, e.EventType()) }
+}
+
+// The FieldRef type refers to a field of typed events, see Field().
+type FieldRef struct {
+    path []string
+}
+
+// Returns a reference to the event field named by path, as used in filter
+// expressions. The path is a dotted list of lower case names, optionally
+// prefixed by the event type, where field names ignore case and underscores:
+// "circ.status" is the Status field of CIRC events and "stream.source_addr"
+// the SourceAddr field of STREAM events. String maps are indexed by a further
+// component, e.g. "status_client.arguments.COUNT".
+//
+// A field an event doesn't have never matches, so "circ.status == BUILT" is
+// false for all but CIRC events. Slice fields match when any element does.
+func Field(path string) FieldRef {
+    return FieldRef{strings.Split(path, ".")}
+}
+
+// Returns a Filter matching events whose field equals value.
+func (r FieldRef) Equals(value string) Filter {
+    return r.compare(func(v string) bool { return v == value })
+}
+
+// Returns a Filter matching events whose field doesn't equal value. Events
+// without the field don't match.
+func (r FieldRef) NotEquals(value string) Filter {
+    return r.compare(func(v string) bool { return v != value })
+}
+
+// Returns a Filter matching events whose field matches the glob pattern, where
+// "*" matches any run of characters and "?" any single character. Patterns
+// without a colon are also tried against the host of "host:port" values, so
+// "*.onion" matches the STREAM target "example.onion:80".
+func (r FieldRef) Matches(pattern string) Filter {
+    return r.compare(func(v string) bool { return __glob_match(pattern, v) })
+}
+
+// Returns a Filter matching events whose field doesn't match the glob pattern.
+// Events without the field don't match.
+func (r FieldRef) NotMatches(pattern string) Filter {
+    return r.compare(func(v string) bool { return !__glob_match(pattern, v) })
+}
+
+// Returns a Filter matching events whose field is numerically less than value.
+func (r FieldRef) Less(value float64) Filter {
+    return r.numeric(func(n float64) bool { return n < value })
+}
+
+// Returns a Filter matching events whose field is numerically less than or
+// equal to value.
+func (r FieldRef) LessOrEqual(value float64) Filter {
+    return r.numeric(func(n float64) bool { return n <= value })
+}
+
+// Returns a Filter matching events whose field is numerically greater than
+// value.
+func (r FieldRef) Greater(value float64) Filter {
+    return r.numeric(func(n float64) bool { return n > value })
+}
+
+// Returns a Filter matching events whose field is numerically greater than or
+// equal to value.
+func (r FieldRef) GreaterOrEqual(value float64) Filter {
+    return r.numeric(func(n float64) bool { return n >= value })
+}
+
+// Returns a Filter applying match to the values of the field that are numbers.
+func (r FieldRef) numeric(match func(float64) bool) Filter {
+    return r.compare(func(v string) bool {
+        n, e := strconv.ParseFloat(v, 64)
+        return e == nil && match(n)
+    })
+}
+
+// Returns a Filter applying match to the values of the field.
+func (r FieldRef) compare(match func(string) bool) Filter {
+    return func(e Event) bool {
+        for _, v := range fieldValues(e, r.path) {
+            if match(v) { return true }
+        }
+        return false
+    }
+}
+
+// Cache of struct field indexes by event type and normalised field name.
+var fieldIndexes sync.Map
+
+type fieldKey struct {
+    t    reflect.Type
+    name string
+}
+
+// Returns the values of the field named by path in event as strings, or nil if
+// event has no such field.
+func fieldValues(event Event, path []string) []string {
+    if len(path) > 1 && __normalise_name(path[0]) == __normalise_name(event.EventType()) {
+        path = path[1:]
+    }
+
+    v := reflect.ValueOf(event)
+    for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+        if v.IsNil() { return nil }
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct { return nil }
+
+    name := __normalise_name(path[0])
+    key  := fieldKey{v.Type(), name}
+
+    index, ok := fieldIndexes.Load(key)
+    if !ok {
+        field, found := v.Type().FieldByNameFunc(func(n string) bool {
+            return __normalise_name(n) == name
+        })
+        if found && field.IsExported() {
+            index = field.Index
+        } else {
+            index = []int(nil)
+        }
+        fieldIndexes.Store(key, index)
+    }
+    if index.([]int) == nil { return nil }
+
+    field, e := v.FieldByIndexErr(index.([]int))
+    if e != nil { return nil }
+
+    switch field.Kind() {
+    case reflect.Map:
+        if len(path) != 2 || field.Type().Key().Kind() != reflect.String { return nil }
+        value := field.MapIndex(reflect.ValueOf(path[1]).Convert(field.Type().Key()))
+        if !value.IsValid() { return nil }
+        if s, ok := __format_value(value); ok { return []string{s} }
+
+    case reflect.Slice:
+        if len(path) != 1 { return nil }
+        results := make([]string, 0, field.Len())
+        for i := 0; i < field.Len(); i++ {
+            if s, ok := __format_value(field.Index(i)); ok { results = append(results, s) }
+        }
+        return results
+
+    default:
+        if len(path) != 1 { return nil }
+        if s, ok := __format_value(field); ok { return []string{s} }
+    }
+    return nil
+}
+
+// Formats a scalar field value for comparison, times as RFC 3339.
+func __format_value(v reflect.Value) (string, bool) {
+    if t, ok := v.Interface().(time.Time); ok {
+        if t.IsZero() { return "", true }
+        return t.Format(time.RFC3339), true
+    }
+
+    switch v.Kind() {
+    case reflect.String:
+        return v.String(), true
+    case reflect.Bool:
+        return strconv.FormatBool(v.Bool()), true
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return strconv.FormatInt(v.Int(), 10), true
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return strconv.FormatUint(v.Uint(), 10), true
+    case reflect.Float32, reflect.Float64:
+        return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+    }
+    return "", false
+}
+
+// Lower cases name and strips underscores, so "SOURCE_ADDR" and "SourceAddr"
+// compare equal.
+func __normalise_name(name string) string {
+    return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// Reports whether value, or its host when value is "host:port" and pattern has
+// no colon, matches the glob pattern.
+func __glob_match(pattern, value string) bool {
+    if __glob(pattern, value) { return true }
+    if strings.Contains(pattern, ":") { return false }
+    host, _, e := net.SplitHostPort(value)
+    return e == nil && __glob(pattern, host)
+}
+
+// Matches value against pattern, where "*" matches any run of characters and
+// "?" a single character.
+func __glob(pattern, value string) bool {
+    p, v := 0, 0
+    star, mark := -1, 0
+    for v < len(value) {
+        switch {
+        case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+            p++
+            v++
+        case p < len(pattern) && pattern[p] == '*':
+            star, mark = p, v
+            p++
+        case star != -1:
+            mark++
+            p, v = star+1, mark
+        default:
+            return false
+        }
+    }
+    for p < len(pattern) && pattern[p] == '*' { p++ }
+    return p == len(pattern)
+}
+
+// Parses a filter expression into a Filter. Expressions compare event fields,
+// named as described by Field(), with a bare word or QuotedString value:
+//
+//     circ.status == BUILT && circ.purpose == HS_SERVICE_REND
+//     stream.target ~ "*.onion"
+//     bw.bytes_read > 1048576 || !(orconn.status == CONNECTED)
+//
+// The operators are == and != for equality, ~ and !~ for glob matches as with
+// FieldRef.Matches(), and <, <=, > and >= for numeric comparison. Comparisons
+// combine with &&, || and !, grouped with parentheses; && binds tighter than
+// ||. Values are case sensitive, as Tor sends them.
+func ParseFilter(expr string) (Filter, error) {
+    p := &filterParser{expr: expr}
+    f, e := p.parseOr()
+    if e != nil { return nil, e }
+    if p.skipSpace(); p.pos < len(expr) {
+        return nil, p.errorf("unexpected %q", expr[p.pos:])
+    }
+    return f, nil
+}
+
+// A recursive descent parser of filter expressions.
+type filterParser struct {
+    expr string
+    pos  int
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+    return &FilterError{p.expr, p.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) skipSpace() {
+    for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t') { p.pos++ }
+}
+
+// Consumes token if it's next in the expression.
+func (p *filterParser) accept(token string) bool {
+    p.skipSpace()
+    if strings.HasPrefix(p.expr[p.pos:], token) {
+        p.pos += len(token)
+        return true
+    }
+    return false
+}
+
+// or := and ( "||" and )*
+func (p *filterParser) parseOr() (Filter, error) {
+    f, e := p.parseAnd()
+    if e != nil { return nil, e }
+
+    filters := []Filter{f}
+    for p.accept("||") {
+        f, e := p.parseAnd()
+        if e != nil { return nil, e }
+        filters = append(filters, f)
+    }
+    if len(filters) == 1 { return f, nil }
+    return AnyOf(filters...), nil
+}
+
+// and := unary ( "&&" unary )*
+func (p *filterParser) parseAnd() (Filter, error) {
+    f, e := p.parseUnary()
+    if e != nil { return nil, e }
+
+    filters := []Filter{f}
+    for p.accept("&&") {
+        f, e := p.parseUnary()
+        if e != nil { return nil, e }
+        filters = append(filters, f)
+    }
+    if len(filters) == 1 { return f, nil }
+    return AllOf(filters...), nil
+}
+
+// unary := "!" unary | "(" or ")" | comparison
+func (p *filterParser) parseUnary() (Filter, error) {
+    if p.accept("!") {
+        f, e := p.parseUnary()
+        if e != nil { return nil, e }
+        return Not(f), nil
+    }
+
+    if p.accept("(") {
+        f, e := p.parseOr()
+        if e != nil { return nil, e }
+        if !p.accept(")") { return nil, p.errorf("expected )") }
+        return f, nil
+    }
+
+    return p.parseComparison()
+}
+
+// comparison := field operator value
+func (p *filterParser) parseComparison() (Filter, error) {
+    p.skipSpace()
+    start := p.pos
+    for p.pos < len(p.expr) && (__is_keyword_char(p.expr[p.pos]) || p.expr[p.pos] == '.') { p.pos++ }
+    if p.pos == start { return nil, p.errorf("expected field name") }
+
+    path := p.expr[start:p.pos]
+    if strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+        return nil, &FilterError{p.expr, start, fmt.Sprintf("invalid field name %q", path)}
+    }
+    ref := Field(path)
+
+    // Longer operators first, so "!=" isn't taken for "!".
+    var op string
+    for _, v := range []string{"==", "!=", "!~", "<=", ">=", "~", "<", ">"} {
+        if p.accept(v) {
+            op = v
+            break
+        }
+    }
+    if op == "" { return nil, p.errorf("expected operator after %q", path) }
+
+    value, e := p.parseValue()
+    if e != nil { return nil, e }
+
+    switch op {
+    case "==": return ref.Equals(value), nil
+    case "!=": return ref.NotEquals(value), nil
+    case "~":  return ref.Matches(value), nil
+    case "!~": return ref.NotMatches(value), nil
+    }
+
+    n, e := strconv.ParseFloat(value, 64)
+    if e != nil { return nil, p.errorf("expected number, got %q", value) }
+
+    switch op {
+    case "<":  return ref.Less(n), nil
+    case "<=": return ref.LessOrEqual(n), nil
+    case ">":  return ref.Greater(n), nil
+    }
+    return ref.GreaterOrEqual(n), nil
+}
+
+// value := QuotedString | word
+func (p *filterParser) parseValue() (string, error) {
+    p.skipSpace()
+    if p.pos >= len(p.expr) { return "", p.errorf("expected value") }
+
+    if p.expr[p.pos] == '"' {
+        end := p.pos + 1
+        for ; end < len(p.expr) && p.expr[end] != '"'; end++ {
+            if p.expr[end] == '\\' { end++ }
+        }
+        if end >= len(p.expr) { return "", p.errorf("unterminated string") }
+
+        value, next := __unquote(p.expr, p.pos)
+        p.pos = next
+        return value, nil
+    }
+
+    start := p.pos
+    for p.pos < len(p.expr) && !strings.ContainsRune(" \t()!=<>~&|\"", rune(p.expr[p.pos])) { p.pos++ }
+    if p.pos == start { return "", p.errorf("expected value") }
+    return p.expr[start:p.pos], nil
+}
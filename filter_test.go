@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "errors"
+    "testing"
+)
+
+// Decodes the single line event reply line.
+func decodeLine(t *testing.T, line string) Event {
+    event, e := DecodeEvent(ResponseBuffer{EndReplyLine: EndReplyLine(line), RawLines: []string{line}})
+    if e != nil { t.Fatal(e) }
+    return event
+}
+
+func TestParseFilter(t *testing.T) {
+    events := []Event{
+        decodeLine(t, "650 CIRC 5 BUILT $A~a,$B~b BUILD_FLAGS=IS_INTERNAL,NEED_CAPACITY PURPOSE=HS_SERVICE_REND TIME_CREATED=2024-01-02T03:04:05.000000"),
+        decodeLine(t, "650 STREAM 9 NEW 0 example.onion:80 SOURCE_ADDR=127.0.0.1:5000"),
+        decodeLine(t, "650 BW 2000000 10"),
+    }
+
+    // Whether each expression matches the CIRC, STREAM and BW event.
+    tests := []struct {
+        expr string
+        want [3]bool
+    }{
+        {`circ.status == BUILT && circ.purpose == HS_SERVICE_REND`, [3]bool{true, false, false}},
+        {`stream.target ~ "*.onion"`, [3]bool{false, true, false}},
+        {`stream.target ~ "*.onion:8?"`, [3]bool{false, true, false}},
+        {`stream.target !~ "*.onion"`, [3]bool{false, false, false}},
+        {`status != BUILT`, [3]bool{false, true, false}},
+        {`circ.build_flags == NEED_CAPACITY`, [3]bool{true, false, false}},
+        {`bw.bytes_read > 1048576 || !(circ.status == BUILT)`, [3]bool{false, true, true}},
+        {`bw.BytesRead >= 2000000 && bytes_written <= 10`, [3]bool{false, false, true}},
+        {`circ.time_created == "2024-01-02T03:04:05Z"`, [3]bool{true, false, false}},
+        {`circ.nosuch == x || stream.source_addr ~ "127.*"`, [3]bool{false, true, false}},
+        {`(circ.status==BUILT||stream.status==NEW)&&!type==BW`, [3]bool{true, true, false}},
+    }
+    for _, v := range tests {
+        f, e := ParseFilter(v.expr)
+        if e != nil { t.Errorf("ParseFilter(%q): %v", v.expr, e); continue }
+        for i, event := range events {
+            if got := f(event); got != v.want[i] {
+                t.Errorf("%s on %s: expected %v, got %v", v.expr, event.EventType(), v.want[i], got)
+            }
+        }
+    }
+}
+
+func TestParseFilterErrors(t *testing.T) {
+    tests := []string{``, `circ.status`, `circ.status ==`, `(a == b`, `a == "x`, `a < x`, `a == b c`, `.a == b`, `a == b &&`}
+    for _, v := range tests {
+        if _, e := ParseFilter(v); !errors.Is(e, ErrInvalidFilter) { t.Errorf("ParseFilter(%q): expected ErrInvalidFilter, got %v", v, e) }
+    }
+}
+
+func TestFilterBuilder(t *testing.T) {
+    circ := decodeLine(t, "650 CIRC 5 BUILT PURPOSE=HS_SERVICE_REND")
+    stream := decodeLine(t, "650 STREAM 9 NEW 0 example.onion:80")
+
+    f := Field("circ.purpose").Equals("HS_SERVICE_REND").And(Field("circ.status").Matches("BUI*"))
+    if !f(circ) || f(stream) { t.Fatal("Unexpected match of And()") }
+
+    f = AnyOf(EventIs(EVENT_STREAM), Not(Field("status").Equals("BUILT")))
+    if f(circ) || !f(stream) { t.Fatal("Unexpected match of AnyOf()") }
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// The HeartbeatPolicy type configures periodic health checks of a Controller's
+// connection, see Controller.HeartbeatPolicy. Every Interval a cheap
+// "GETINFO version" request is sent, and a reply not arriving within Timeout
+// marks the connection STATE_DEGRADED. After MaxFailures consecutive failures
+// the connection is torn down, so half-open connections are noticed and
+// ReconnectPolicy, if set, takes over.
+type HeartbeatPolicy struct {
+    Interval    time.Duration
+    Timeout     time.Duration
+    MaxFailures int
+}
+
+// Instantiates a new HeartbeatPolicy with sensible defaults.
+func NewHeartbeatPolicy() *HeartbeatPolicy {
+    return &HeartbeatPolicy{
+        Interval:    30 * time.Second,
+        Timeout:     10 * time.Second,
+        MaxFailures: 2,
+    }
+}
+
+// Send heartbeats on the current connection according to HeartbeatPolicy,
+// until the reader stops or stop is closed by Close().
+func (c *Controller) heartbeat(readerDone, stop chan struct{}) {
+    policy := c.HeartbeatPolicy
+    if policy == nil || policy.Interval <= 0 { return }
+
+    ticker := time.NewTicker(policy.Interval)
+    defer ticker.Stop()
+
+    failures := 0
+    for {
+        select {
+            case <-ticker.C:
+            case <-readerDone:
+                return
+            case <-stop:
+                return
+        }
+
+        e := c.ping(policy.Timeout)
+        if e == nil {
+            if failures > 0 {
+                failures = 0
+                c.setState(STATE_READY, nil)
+            }
+            continue
+        }
+
+        failures++
+        c.logger().Warn("Heartbeat failed.", "failures", failures, "error", e)
+
+        if policy.MaxFailures > 0 && failures >= policy.MaxFailures {
+            c.teardown(fmt.Errorf("Heartbeat failed %d times: %w", failures, e))
+            return
+        }
+        c.setState(STATE_DEGRADED, e)
+    }
+}
+
+// Send a single heartbeat, any reply from Tor, even a negative one, proves the
+// connection alive. A late reply is always discarded, whatever TimeoutAction
+// says, so only MaxFailures decides when the connection is torn down.
+func (c *Controller) ping(timeout time.Duration) error {
+    ctx := context.Background()
+    if timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
+
+    request, e := NewRequestBuilder(COMMAND_GETINFO).Arg("version").Build()
+    if e != nil { return e }
+    e = c.requestContext(ctx, request, new(GetInfoResponse), TIMEOUT_DISCARD_REPLY)
+
+    var reply *ReplyError
+    if errors.As(e, &reply) { return nil }
+    return e
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "context"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestHeartbeatIgnoresTimeoutAction(t *testing.T) {
+    // Heartbeats go unanswered.
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETINFO version") { return nil }
+        return fakeReply(line)
+    })
+    c.TimeoutAction   = TIMEOUT_CLOSE_CONNECTION
+    c.HeartbeatPolicy = &HeartbeatPolicy{Interval: 20 * time.Millisecond, Timeout: 10 * time.Millisecond, MaxFailures: 3}
+
+    states := c.WatchState(context.Background(), 16)
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    seen := make([]ConnectionState, 0)
+    for {
+        select {
+            case change := <-states:
+                seen = append(seen, change.State)
+                if change.State != STATE_CLOSED { continue }
+
+                degraded := 0
+                for _, v := range seen {
+                    if v == STATE_DEGRADED { degraded++ }
+                }
+                // Each failure short of MaxFailures reports Degraded.
+                if degraded != 2 { t.Fatalf("Expected Degraded twice before Closed, got %v", seen) }
+                return
+
+            case <-time.After(time.Second):
+                t.Fatalf("Timed out waiting for connection to close, got %v", seen)
+        }
+    }
+}
@@ -24,9 +24,17 @@
 package torc
 
 import (
+    "strings"
     "time"
 )
 
+// The default time to wait for a reply to a request, when the request context
+// carries no deadline of its own.
+const DEFAULT_RESPONSE_TIMEOUT = time.Second * 5
+
+// The default time Close() waits for Tor to acknowledge QUIT.
+const DEFAULT_CLOSE_TIMEOUT = time.Second * 2
+
 // The Message interface describes the interface used to serialize outbound
 // control messages.
 type Message interface {
@@ -49,6 +57,15 @@ type ControlResponse interface {
     StatusText() string
 
     IsSuccess() bool
+    Err() error
+}
+
+// The ResponseDecoder interface describes the interface used to populate a
+// response from the reply to its request, see Do(). Response types embedding
+// BaseControlResponse implement it, and may override DecodeResponse to parse
+// the reply eagerly.
+type ResponseDecoder interface {
+    DecodeResponse(request ControlRequest, buffer ResponseBuffer) error
 }
 
 // The BaseControlRequest type is the base type of all command request tpes.
@@ -62,7 +79,16 @@ func NewRequest(data string) *BaseControlRequest {
     m := new(BaseControlRequest)
     m.buffer = make(LineBuffer, 0)
     m.buffer = append(m.buffer, data)
-    m.timeout = time.Second * 5
+    m.timeout = DEFAULT_RESPONSE_TIMEOUT
+    return m
+}
+
+// Instantiates a new multi-line BaseControlRequest instance, as used by
+// LOADCONF, POSTDESCRIPTOR and HSPOST. The request is serialized as
+// "+" data, followed by the lines of body escaped with EscapeData().
+func NewMultilineRequest(data string, body string) *BaseControlRequest {
+    m := NewRequest("+" + data)
+    m.buffer = append(m.buffer, EscapeData(body)...)
     return m
 }
 
@@ -70,10 +96,37 @@ func (m *BaseControlRequest) ResponseTimeout() time.Duration {
     return m.timeout
 }
 
+// Sets the time to wait for a reply when the request context carries no
+// deadline, a zero duration waits for as long as the context allows.
+func (m *BaseControlRequest) SetResponseTimeout(timeout time.Duration) {
+    m.timeout = timeout
+}
+
 func (m *BaseControlRequest) Serialize() LineBuffer {
     return m.buffer
 }
 
+// Returns the request lines with secrets masked, see RedactRequest().
+func (m *BaseControlRequest) String() string {
+    return strings.Join(RedactRequest(m.buffer), "\n")
+}
+
+// The rawRequest type is a single line request transmitted as line, which
+// holds the terminating CRLF. It's used for secrets, which the caller zeroes
+// once the request completes, so Serialize() reports only the command.
+type rawRequest struct {
+    command string
+    line    []byte
+}
+
+func (m *rawRequest) ResponseTimeout() time.Duration {
+    return DEFAULT_RESPONSE_TIMEOUT
+}
+
+func (m *rawRequest) Serialize() LineBuffer {
+    return LineBuffer{m.command + " " + REDACTED}
+}
+
 // The BaseControlResponse type is the base type for all command response types.
 type BaseControlResponse struct {
     Request ControlRequest
@@ -81,6 +134,13 @@ type BaseControlResponse struct {
     Buffer  ResponseBuffer
 }
 
+// Populates the response with the reply to request.
+func (r *BaseControlResponse) DecodeResponse(request ControlRequest, buffer ResponseBuffer) error {
+    r.Request = request
+    r.Buffer  = buffer
+    return nil
+}
+
 // Instantiates a new BaseControlResponse object.
 func NewResponse(req ControlRequest, data ResponseBuffer) *BaseControlResponse {
     m := new(BaseControlResponse)
@@ -131,6 +191,13 @@ func (r *BaseControlResponse) StatusText() string {
     return r.Buffer.EndReplyLine.StatusText()
 }
 
+// Returns a *ReplyError describing a negative completion reply, or nil if the
+// request was successful. See errors.go for the error classes it matches.
+func (r *BaseControlResponse) Err() error {
+    if r.IsSuccess() { return nil }
+    return &ReplyError{r.Status(), r.StatusText(), r.Request}
+}
+
 // Returns a boolean indicating whether the request was successful, this is an
 // abstraction over the Status() method.
 func (r *BaseControlResponse) IsSuccess() bool {
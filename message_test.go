@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+
+package torc
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestEscapeData(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        want LineBuffer
+    }{
+        {"empty", "", LineBuffer{"."}},
+        {"single line", "SocksPort 9050", LineBuffer{"SocksPort 9050", "."}},
+        {"trailing newline", "SocksPort 9050\n", LineBuffer{"SocksPort 9050", "."}},
+        {"CRLF", "SocksPort 9050\r\nLog notice stdout\r\n", LineBuffer{"SocksPort 9050", "Log notice stdout", "."}},
+        {"blank line kept", "a\n\nb", LineBuffer{"a", "", "b", "."}},
+        {"dot stuffing", ".\n..\n.a\na.", LineBuffer{"..", "...", "..a", "a.", "."}},
+        {"lone newline", "\n", LineBuffer{"."}},
+    }
+
+    for _, v := range tests {
+        t.Run(v.name, func(t *testing.T) {
+            if got := EscapeData(v.body); !reflect.DeepEqual(got, v.want) { t.Errorf("Expected %q, got %q", v.want, got) }
+        })
+    }
+}
+
+func TestNewMultilineRequest(t *testing.T) {
+    tests := []struct {
+        name string
+        body string
+        want string
+    }{
+        {"empty", "", "+LOADCONF\r\n.\r\n"},
+        {"body", "SocksPort 9050\nLog notice stdout\n", "+LOADCONF\r\nSocksPort 9050\r\nLog notice stdout\r\n.\r\n"},
+        {"dot stuffing", "a\n.\n.b", "+LOADCONF\r\na\r\n..\r\n..b\r\n.\r\n"},
+    }
+
+    for _, v := range tests {
+        t.Run(v.name, func(t *testing.T) {
+            got := string(NewMultilineRequest(COMMAND_LOADCONF, v.body).Serialize().Normalize())
+            if got != v.want { t.Errorf("Expected %q, got %q", v.want, got) }
+        })
+    }
+}
@@ -30,29 +30,67 @@ import (
     "strings"
 )
 
+// The Parser type reads replies from the control socket, assembling reply lines
+// into ResponseBuffers. Protocol violations are reported on the Errors()
+// channel, and any read error terminates the parser.
 type Parser struct {
     reader *bufio.Reader
 
     ch chan ResponseBuffer
 
+    // Asynchronous event output channel.
+    events chan ResponseBuffer
+
+    // Protocol violation output channel.
+    errors chan error
+
+    // Reason the parser stopped, set once Run() returns.
+    err error
+
     // Parser state.
     buffer *ResponseBuffer
     dataReplyLine DataReplyLine
 
     bufferRaw []string
 
-    isReady bool
+    status int
     isMultiLine bool
+
+    logger Logger
 }
 
-func NewParser(r io.Reader, out chan ResponseBuffer) *Parser {
+// Creates a new Parser instance reading from r. Synchronous command replies are
+// posted to out, while asynchronous (6yz) event replies are posted to events.
+// Both channels are closed when Run() returns.
+func NewParser(r io.Reader, out chan ResponseBuffer, events chan ResponseBuffer) *Parser {
     p := new(Parser)
     p.reader = bufio.NewReader(r)
     p.ch = out
+    p.events = events
+    p.errors = make(chan error, 16)
+    p.logger = nopLogger{}
     p.Reset()
     return p
 }
 
+// Sets the Logger replies are logged to at debug level, it must be called
+// before Run().
+func (p *Parser) SetLogger(l Logger) {
+    p.logger = l
+}
+
+// Returns the channel protocol violations are reported on, it's closed when
+// Run() returns. Violations are dropped when the channel is full.
+func (p *Parser) Errors() <-chan error {
+    return p.errors
+}
+
+// Returns the reason the parser stopped, io.EOF when the remote closed the
+// connection, or nil while it's still running.
+func (p *Parser) Err() error {
+    return p.err
+}
+
 // Perform parser state reset.
 func (p *Parser) Reset() {
     p.buffer = new(ResponseBuffer)
@@ -61,78 +99,107 @@ func (p *Parser) Reset() {
 
     p.bufferRaw = make([]string, 0)
 
-    p.isReady = true
+    p.status = -1
     p.isMultiLine = false
 }
 
-// Perform post to channel.
+// Perform post to channel, asynchronous events are routed to the events
+// channel so they're never mistaken for a command reply.
 func (p *Parser) post() {
-    LogComms(">>", p.bufferRaw)
-    p.ch<- *p.buffer
+    logComms(p.logger, COMMS_RECV, p.bufferRaw)
+    p.buffer.RawLines = p.bufferRaw
+    if p.buffer.IsAsync() {
+        p.events<- *p.buffer
+    } else {
+        p.ch<- *p.buffer
+    }
     p.Reset()
 }
 
-// Run parser loop on reader.
+// Report a protocol violation without blocking the reader.
+func (p *Parser) report(ln, reason string) {
+    select {
+        case p.errors<- &ProtocolError{Line: ln, Reason: reason}:
+        default:
+            p.logger.Warn("Dropped protocol error.", "reason", reason)
+    }
+}
+
+// Run parser loop on reader, until a read error occurs.
 func (p *Parser) Run() {
+    defer close(p.ch)
+    defer close(p.events)
+    defer close(p.errors)
+
     for {
         ln, e := p.reader.ReadString('\n')
-        if e == io.EOF {
-            break // If we've got EOF, then quit reader.
-        } else if e != nil {
-            // For debugging, if we get here then we need to add more conds.
-            LogWarn("Error reading on socket: %v", e)
-            continue
-        }
+        if e != nil {
+            // Anything left over is an incomplete line or reply.
+            if ln != "" {
+                p.report(ln, "connection closed mid-line")
+            } else if len(p.bufferRaw) > 0 {
+                p.report(p.bufferRaw[0], "connection closed mid-reply")
+            }
 
-        // Check for and remove line ending from stanza.
-        // TODO: Should probably buffer here to create full line.
-        if !strings.HasSuffix(ln, "\r\n") {
-            LogWarn("Protocol error, no line ending!")
-            continue
+            p.err = e
+            return
         }
 
-        // Trim incoming data and insert it into message data buffer.
-        ln = strings.TrimSuffix(ln, "\r\n")
-        p.bufferRaw = append(p.bufferRaw, ln)
-
-        //   Check to see if we're waiting for a new message. If we are, then we
-        // should expect 3 different possible formats:
-        if p.isReady {
-            switch {
-            case isEndReplyLine(ln):
-                p.buffer.EndReplyLine = EndReplyLine(ln)
-                p.post()
-                continue
-
-            case isMidReplyLine(ln):
-                p.buffer.MidReplyLines = append(p.buffer.MidReplyLines, MidReplyLine(ln))
-                continue
-
-            case isDataReplyLine(ln):
-                p.dataReplyLine = DataReplyLine{ln}
-                p.isReady = false
-                p.isMultiLine = true
-                continue
-
-            default:
-                LogError("Failed to parse initial message line!")
-                p.Reset()
-                continue
-            }
+        // Remove line ending from stanza, tolerating a missing CR.
+        if strings.HasSuffix(ln, "\r\n") {
+            ln = strings.TrimSuffix(ln, "\r\n")
+        } else {
+            ln = strings.TrimSuffix(ln, "\n")
+            p.report(ln, "line not terminated by CRLF")
         }
 
-        if p.isMultiLine {
-            if !isEndOfData(ln) {
-                p.dataReplyLine = append(p.dataReplyLine, ln)
-                continue
-            }
+        p.parseLine(ln)
+    }
+}
 
-            p.buffer.DataReplyLines = append(p.buffer.DataReplyLines, p.dataReplyLine)
+// Parse a single line, without its line ending.
+func (p *Parser) parseLine(ln string) {
+    p.bufferRaw = append(p.bufferRaw, ln)
 
-            p.isReady = true
+    // Within a data reply, collect lines until the terminating ".", removing
+    // the escaping "." from lines starting with one.
+    if p.isMultiLine {
+        if isEndOfData(ln) {
+            p.buffer.DataReplyLines = append(p.buffer.DataReplyLines, p.dataReplyLine)
             p.isMultiLine = false
-            continue
+            return
+        }
+
+        ln = strings.TrimPrefix(ln, ".")
+        p.dataReplyLine = append(p.dataReplyLine, ln)
+        return
+    }
+
+    // Otherwise we expect 3 different possible formats, all lines of a reply
+    // sharing the same status code.
+    if isReplyLine(ln) {
+        status, _ := strconv.Atoi(ln[:3])
+        if p.status != -1 && status != p.status {
+            p.report(ln, "status code changed within reply")
         }
+        p.status = status
+    }
+
+    switch {
+    case isEndReplyLine(ln):
+        p.buffer.EndReplyLine = EndReplyLine(ln)
+        p.post()
+
+    case isMidReplyLine(ln):
+        p.buffer.MidReplyLines = append(p.buffer.MidReplyLines, MidReplyLine(ln))
+
+    case isDataReplyLine(ln):
+        p.dataReplyLine = DataReplyLine{ln}
+        p.isMultiLine = true
+
+    default:
+        p.report(ln, "malformed reply line")
+        p.Reset()
     }
 }
 
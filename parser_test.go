@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "errors"
+    "io"
+    "strings"
+    "testing"
+    "testing/iotest"
+)
+
+// Runs a Parser over input to completion, returning the replies, events and
+// protocol errors it produced.
+func parseAll(r io.Reader) ([]ResponseBuffer, []ResponseBuffer, []error, *Parser) {
+    out    := make(chan ResponseBuffer, 16)
+    events := make(chan ResponseBuffer, 16)
+    p := NewParser(r, out, events)
+    p.Run()
+
+    replies := make([]ResponseBuffer, 0)
+    for v := range out { replies = append(replies, v) }
+    asyncs := make([]ResponseBuffer, 0)
+    for v := range events { asyncs = append(asyncs, v) }
+    errs := make([]error, 0)
+    for e := range p.Errors() { errs = append(errs, e) }
+    return replies, asyncs, errs, p
+}
+
+func TestParserDataReplyUnescaping(t *testing.T) {
+    input := "250+desc=\r\n..leading dot\r\nplain\r\n...\r\n.\r\n250 OK\r\n"
+    replies, _, errs, _ := parseAll(strings.NewReader(input))
+
+    if len(errs) != 0 { t.Fatalf("Unexpected protocol errors: %v", errs) }
+    if len(replies) != 1 || len(replies[0].DataReplyLines) != 1 { t.Fatalf("Unexpected replies: %v", replies) }
+
+    got := strings.Join(replies[0].DataReplyLines[0][1:], "|")
+    if want := ".leading dot|plain|.."; got != want { t.Fatalf("Expected %q, got %q", want, got) }
+}
+
+func TestParserPartialReads(t *testing.T) {
+    input := "650 CIRC 1 LAUNCHED\r\n250-version=0.4.8.1\r\n250 OK\r\n"
+    replies, events, errs, _ := parseAll(iotest.OneByteReader(strings.NewReader(input)))
+
+    if len(errs) != 0 { t.Fatalf("Unexpected protocol errors: %v", errs) }
+    if len(replies) != 1 || len(replies[0].MidReplyLines) != 1 { t.Fatalf("Unexpected replies: %v", replies) }
+    if len(events) != 1 || events[0].EventName() != EVENT_CIRC { t.Fatalf("Unexpected events: %v", events) }
+}
+
+func TestParserProtocolErrors(t *testing.T) {
+    input := "bogus\r\n250-a=1\n251 OK\r\n250 partial"
+    _, _, errs, p := parseAll(strings.NewReader(input))
+
+    reasons := []string{
+        "malformed reply line",
+        "line not terminated by CRLF",
+        "status code changed within reply",
+        "connection closed mid-line",
+    }
+    if len(errs) != len(reasons) { t.Fatalf("Expected %d protocol errors, got %v", len(reasons), errs) }
+    for i, v := range errs {
+        var pe *ProtocolError
+        if !errors.As(v, &pe) || pe.Reason != reasons[i] { t.Errorf("Expected %q, got %v", reasons[i], v) }
+    }
+    if p.Err() != io.EOF { t.Fatalf("Expected io.EOF, got %v", p.Err()) }
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "sync"
+    "time"
+)
+
+// The PasswordProvider interface describes a source of the control port
+// password, used by PasswordAuthenticator. The returned slice is zeroed by the
+// caller once used, so providers must return a fresh copy on each call.
+type PasswordProvider interface {
+    Password(context.Context) ([]byte, error)
+}
+
+// The PasswordProviderFunc type is an adapter to allow the use of ordinary
+// functions as a PasswordProvider.
+type PasswordProviderFunc func(context.Context) ([]byte, error)
+
+func (f PasswordProviderFunc) Password(ctx context.Context) ([]byte, error) {
+    return f(ctx)
+}
+
+// Returns a PasswordProvider that always provides password.
+func StaticPasswordProvider(password string) PasswordProvider {
+    return PasswordProviderFunc(func(context.Context) ([]byte, error) {
+        return []byte(password), nil
+    })
+}
+
+// Returns a PasswordProvider that reads the password from the environment
+// variable name at authentication time.
+func EnvPasswordProvider(name string) PasswordProvider {
+    return PasswordProviderFunc(func(context.Context) ([]byte, error) {
+        v, ok := os.LookupEnv(name)
+        if !ok {
+            return nil, fmt.Errorf("Password environment variable %s not set.", name)
+        }
+        return []byte(v), nil
+    })
+}
+
+// Returns a PasswordProvider that reads the password from the file at path at
+// authentication time, trailing line endings are removed.
+func FilePasswordProvider(path string) PasswordProvider {
+    return PasswordProviderFunc(func(context.Context) ([]byte, error) {
+        data, e := ioutil.ReadFile(path)
+        if e != nil { return nil, e }
+
+        password := bytes.TrimRight(data, "\r\n")
+        result := append([]byte(nil), password...)
+        zero(data)
+        return result, nil
+    })
+}
+
+// Returns a PasswordProvider that prompts for the password on the controlling
+// terminal, with echo disabled, each time it's needed. The terminal is always
+// restored before the prompt returns, including when ctx ends first.
+func PromptPasswordProvider(prompt string) PasswordProvider {
+    return PasswordProviderFunc(func(ctx context.Context) ([]byte, error) {
+        tty, e := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+        if e != nil { return nil, e }
+        defer tty.Close()
+
+        if _, e := tty.WriteString(prompt); e != nil { return nil, e }
+        defer tty.WriteString("\n")
+
+        disabled, e := disableEcho(tty)
+        if e != nil { return nil, e }
+
+        var once sync.Once
+        restore := func() { once.Do(disabled) }
+        defer restore()
+
+        // Abandon the prompt if the context ends first, by expiring the read.
+        // Should the terminal not support deadlines, restore it and close it
+        // instead.
+        stop := context.AfterFunc(ctx, func() {
+            if tty.SetReadDeadline(time.Now()) != nil {
+                restore()
+                tty.Close()
+            }
+        })
+        defer stop()
+
+        password, e := readLine(tty)
+        if e != nil && ctx.Err() != nil { return nil, ctx.Err() }
+        return password, e
+    })
+}
+
+// Read a line from f a byte at a time, so no copies of it are left in buffers.
+func readLine(f *os.File) ([]byte, error) {
+    result := make([]byte, 0, 64)
+    b := make([]byte, 1)
+    for {
+        n, e := f.Read(b)
+        if n == 1 {
+            if b[0] == '\n' { break }
+            if b[0] == '\r' { continue }
+            if len(result) == cap(result) {
+                grown := append(make([]byte, 0, cap(result) * 2), result...)
+                zero(result)
+                result = grown
+            }
+            result = append(result, b[0])
+            continue
+        }
+        if e != nil {
+            zero(result)
+            return nil, e
+        }
+    }
+    return result, nil
+}
+
+// Overwrite secret data in memory.
+func zero(data []byte) {
+    for i := range data {
+        data[i] = 0
+    }
+}
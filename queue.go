@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "sync"
+    "sync/atomic"
+)
+
+// The default number of events queued awaiting dispatch, see
+// Controller.EventQueueSize.
+const DEFAULT_EVENT_QUEUE_SIZE = 1024
+
+// A bounded FIFO of asynchronous event replies, decoupling the reader from
+// event dispatch so slow handlers or subscribers never delay command replies.
+// Once full the oldest event is dropped, and counted in dropped.
+type eventQueue struct {
+    mutex   sync.Mutex
+    cond    *sync.Cond
+    items   []ResponseBuffer
+    size    int
+    closed  bool
+    dropped *atomic.Uint64
+}
+
+func newEventQueue(size int, dropped *atomic.Uint64) *eventQueue {
+    if size <= 0 { size = DEFAULT_EVENT_QUEUE_SIZE }
+
+    q := &eventQueue{size: size, dropped: dropped}
+    q.cond = sync.NewCond(&q.mutex)
+    return q
+}
+
+// Queue everything received on events, closing the queue once events is
+// closed by the parser.
+func (q *eventQueue) fill(events chan ResponseBuffer) {
+    for buff := range events {
+        q.mutex.Lock()
+        if len(q.items) >= q.size {
+            q.items[0] = ResponseBuffer{}
+            q.items = q.items[1:]
+            q.dropped.Add(1)
+        }
+        q.items = append(q.items, buff)
+        q.mutex.Unlock()
+        q.cond.Signal()
+    }
+
+    q.mutex.Lock()
+    q.closed = true
+    q.mutex.Unlock()
+    q.cond.Broadcast()
+}
+
+// Dequeue the next event reply, waiting for one to arrive. Returns false once
+// the queue is closed and drained.
+func (q *eventQueue) pop() (ResponseBuffer, bool) {
+    q.mutex.Lock()
+    defer q.mutex.Unlock()
+
+    for len(q.items) == 0 && !q.closed {
+        q.cond.Wait()
+    }
+    if len(q.items) == 0 {
+        return ResponseBuffer{}, false
+    }
+
+    buff := q.items[0]
+    q.items[0] = ResponseBuffer{}
+    q.items = q.items[1:]
+    return buff, true
+}
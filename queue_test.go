@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "fmt"
+    "sync/atomic"
+    "testing"
+)
+
+func TestEventQueueDropsOldest(t *testing.T) {
+    var dropped atomic.Uint64
+    q := newEventQueue(3, &dropped)
+
+    events := make(chan ResponseBuffer)
+    done := make(chan struct{})
+    go func() {
+        q.fill(events)
+        close(done)
+    }()
+
+    for i := 0; i < 5; i++ {
+        events<- ResponseBuffer{EndReplyLine: EndReplyLine(fmt.Sprintf("650 BW %d 0", i))}
+    }
+    close(events)
+    <-done
+
+    if n := dropped.Load(); n != 2 { t.Fatalf("Expected 2 dropped events, got %d", n) }
+
+    for i := 2; i < 5; i++ {
+        buff, ok := q.pop()
+        if !ok { t.Fatal("Queue closed early") }
+        if want := fmt.Sprintf("650 BW %d 0", i); string(buff.EndReplyLine) != want {
+            t.Fatalf("Expected %q, got %q", want, buff.EndReplyLine)
+        }
+    }
+    if _, ok := q.pop(); ok { t.Fatal("Expected queue to be closed and drained") }
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "strings"
+    "time"
+)
+
+// The ReconnectPolicy type configures automatic reconnection of a Controller
+// whose connection is lost, see Controller.ReconnectPolicy. Delays between
+// attempts grow exponentially from InitialDelay by Multiplier, up to MaxDelay.
+type ReconnectPolicy struct {
+    InitialDelay time.Duration
+    MaxDelay     time.Duration
+    Multiplier   float64
+
+    // Maximum number of consecutive attempts, zero retries forever.
+    MaxAttempts  int
+}
+
+// Instantiates a new ReconnectPolicy with sensible defaults.
+func NewReconnectPolicy() *ReconnectPolicy {
+    return &ReconnectPolicy{
+        InitialDelay: time.Second,
+        MaxDelay:     time.Minute,
+        Multiplier:   2,
+    }
+}
+
+// Returns the delay to wait before the given zero based reconnection attempt.
+func (p *ReconnectPolicy) Delay(attempt int) time.Duration {
+    delay := float64(p.InitialDelay)
+    for i := 0; i < attempt && delay < float64(p.MaxDelay); i++ {
+        delay *= p.Multiplier
+    }
+    if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+        return p.MaxDelay
+    }
+    return time.Duration(delay)
+}
+
+// An onion service created through AddOnion, recreated on reconnect.
+type onionRecord struct {
+    keyType string
+    keyData Secret
+    flags   []string
+    ports   []string
+}
+
+// Record SETEVENTS subscription for restoration on reconnect.
+func (c *Controller) recordEvents(events []string) {
+    c.restoreMutex.Lock()
+    defer c.restoreMutex.Unlock()
+    c.activeEvents = events
+}
+
+// Record an onion service for recreation on reconnect. Detached services
+// outlive the control connection, so they're never recorded.
+func (c *Controller) recordOnion(r *AddOnionResponse, keyType string, keyData Secret, flags, ports []string) {
+    discardPK := false
+    for _, v := range flags {
+        switch v {
+        case ADD_ONION_FLAG_DETACH:
+            return
+        case ADD_ONION_FLAG_DISCARD_PK:
+            discardPK = true
+        }
+    }
+
+    // Newly generated keys must be recreated with the same key, to keep the
+    // same service address.
+    serviceId, ok := r.ServiceId()
+    if !ok { return }
+
+    if keyType == ONION_KEY_TYPE_NEW {
+        if discardPK {
+            c.logger().Warn("Onion service discards its key, it won't be restored on reconnect.", "service_id", serviceId)
+            return
+        }
+        key, _ := r.PrivateKey()
+        parts := strings.SplitN(key.Reveal(), ":", 2)
+        if len(parts) != 2 { return }
+        keyType, keyData = parts[0], Secret(parts[1])
+    }
+
+    c.restoreMutex.Lock()
+    defer c.restoreMutex.Unlock()
+    c.onions[serviceId] = &onionRecord{keyType, keyData, flags, ports}
+}
+
+// Forget an onion service removed with DelOnion.
+func (c *Controller) forgetOnion(serviceId string) {
+    c.restoreMutex.Lock()
+    defer c.restoreMutex.Unlock()
+    delete(c.onions, serviceId)
+}
+
+// Handle the loss of conn, read by parser, reconnecting when a ReconnectPolicy
+// is set.
+func (c *Controller) connectionLost(parser *Parser, conn net.Conn) {
+    // Requests made from now on fail straight away, rather than being written
+    // to the dead socket and left waiting for a reply.
+    c.writeMutex.Lock()
+    if c.connection != nil && *c.connection == conn { c.connection = nil }
+    c.writeMutex.Unlock()
+    conn.Close()
+
+    c.stateMutex.Lock()
+    current := c.parser == parser
+    ready := current && (c.state == STATE_READY || c.state == STATE_DEGRADED)
+    stop := c.stop
+    cause := c.teardownReason
+    c.stateMutex.Unlock()
+
+    if !current { return }
+
+    select {
+        case <-stop:
+            // Closed by Close(), which finishes the job.
+            c.failPending(ErrClosed)
+            return
+        default:
+    }
+
+    if cause == nil { cause = parser.Err() }
+    e := fmt.Errorf("Connection lost: %w", cause)
+    c.failPending(e)
+
+    // Failed connection attempts are handled by whoever made them.
+    if !ready { return }
+
+    if c.ReconnectPolicy == nil {
+        c.setState(STATE_CLOSED, e)
+        return
+    }
+
+    c.setState(STATE_RECONNECTING, e)
+    go c.reconnect(stop)
+}
+
+// Reconnect according to ReconnectPolicy until successful, the policy gives up
+// or stop is closed by Close().
+func (c *Controller) reconnect(stop chan struct{}) {
+    policy := c.ReconnectPolicy
+
+    for attempt := 0; policy.MaxAttempts == 0 || attempt < policy.MaxAttempts; attempt++ {
+        delay := policy.Delay(attempt)
+        c.logger().Info("Reconnecting.", "delay", delay, "attempt", attempt + 1)
+
+        select {
+            case <-time.After(delay):
+            case <-stop:
+                return
+        }
+
+        // Abort the attempt should Close() be called meanwhile.
+        ctx, cancel := context.WithCancel(context.Background())
+        go func() {
+            select {
+                case <-stop: cancel()
+                case <-ctx.Done():
+            }
+        }()
+
+        e := c.connect(ctx)
+        var restored error
+        if e == nil { restored = c.restore(ctx) }
+        cancel()
+
+        select {
+            case <-stop:
+                // Closed meanwhile, drop whatever the attempt connected.
+                c.closeConnection()
+                return
+            default:
+        }
+
+        if e == nil {
+            c.setState(STATE_READY, restored)
+            c.startHeartbeat()
+            return
+        }
+
+        c.logger().Warn("Reconnection attempt failed.", "error", e)
+        c.setState(STATE_RECONNECTING, e)
+    }
+
+    c.setState(STATE_CLOSED, fmt.Errorf("Gave up reconnecting after %d attempts.", policy.MaxAttempts))
+}
+
+// Restore event subscriptions and onion services on a new connection.
+func (c *Controller) restore(ctx context.Context) error {
+    c.restoreMutex.Lock()
+    events := c.activeEvents
+    onions := make([]*onionRecord, 0, len(c.onions))
+    for _, v := range c.onions {
+        onions = append(onions, v)
+    }
+    c.restoreMutex.Unlock()
+
+    errs := make([]string, 0)
+
+    if len(events) > 0 {
+        _, e := c.SetEventsContext(ctx, events)
+        if e != nil { errs = append(errs, fmt.Sprintf("SETEVENTS: %v", e)) }
+    }
+
+    for _, v := range onions {
+        _, e := c.AddOnionContext(ctx, v.keyType, v.keyData, v.flags, v.ports)
+        if e != nil { errs = append(errs, fmt.Sprintf("ADD_ONION: %v", e)) }
+    }
+
+    if len(errs) > 0 {
+        return fmt.Errorf("Failed to restore state: %s", strings.Join(errs, "; "))
+    }
+    return nil
+}
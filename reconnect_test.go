@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "errors"
+    "net"
+    "testing"
+    "time"
+)
+
+func TestCloseWhileReconnecting(t *testing.T) {
+    c, remotes := newFakeTor(t, fakeReply)
+    c.ReconnectPolicy = &ReconnectPolicy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1}
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    // Hold up the reconnection attempt until after Close() returns.
+    dialing := make(chan struct{})
+    release := make(chan struct{})
+    dialer := c.Dialer
+    c.Dialer = func(network, address string) (net.Conn, error) {
+        close(dialing)
+        <-release
+        return dialer(network, address)
+    }
+
+    (<-remotes).Close()
+    select {
+        case <-dialing:
+        case <-time.After(time.Second):
+            t.Fatal("Timed out waiting for reconnection attempt")
+    }
+
+    c.Close()
+    close(release)
+    time.Sleep(50 * time.Millisecond)
+
+    if state := c.State(); state != STATE_CLOSED { t.Fatalf("Expected Closed, got %s", state) }
+    if e := c.Err(); !errors.Is(e, ErrClosed) { t.Fatalf("Expected ErrClosed, got %v", e) }
+    select {
+        case <-c.Done():
+        default:
+            t.Fatal("Done() reopened after Close()")
+    }
+}
+
+func TestAuthStateDuringReconnect(t *testing.T) {
+    c, remotes := newFakeTor(t, fakeReply)
+    c.ReconnectPolicy = &ReconnectPolicy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1}
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    // Run under -race, these mustn't race the reconnecting goroutine.
+    stop := make(chan struct{})
+    polled := make(chan struct{})
+    go func() {
+        defer close(polled)
+        for {
+            select {
+                case <-stop:
+                    return
+                default:
+                    c.IsAuthenticated()
+                    c.Authenticator()
+                    c.SetPasswordProvider(nil)
+            }
+        }
+    }()
+
+    (<-remotes).Close()
+    select {
+        case <-remotes:
+        case <-time.After(time.Second):
+            t.Fatal("Timed out waiting for reconnection")
+    }
+
+    for deadline := time.Now().Add(time.Second); c.State() != STATE_READY; time.Sleep(time.Millisecond) {
+        if time.Now().After(deadline) { t.Fatal("Timed out waiting for reconnection") }
+    }
+    close(stop)
+    <-polled
+
+    if !c.IsAuthenticated() { t.Error("Expected authenticated after reconnecting") }
+    if a := c.Authenticator(); a == nil || a.MethodName() != "NULL" { t.Errorf("Expected NULL authenticator, got %v", a) }
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "fmt"
+    "io"
+    "log/slog"
+    "regexp"
+    "strings"
+)
+
+// Text substituted for secrets in log output.
+const REDACTED = "[REDACTED]"
+
+// The Secret type holds a sensitive value, such as a password, cookie or onion
+// service private key. It prints as [REDACTED] with fmt, encoding/json and
+// log/slog, use Reveal() to get at the value.
+type Secret string
+
+// Returns the secret value.
+func (s Secret) Reveal() string { return string(s) }
+
+func (s Secret) String() string   { return REDACTED }
+func (s Secret) GoString() string { return REDACTED }
+
+func (s Secret) Format(f fmt.State, verb rune) {
+    io.WriteString(f, REDACTED)
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+    return []byte(REDACTED), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+    return []byte(`"` + REDACTED + `"`), nil
+}
+
+func (s Secret) LogValue() slog.Value {
+    return slog.StringValue(REDACTED)
+}
+
+// Configuration options and reply fields whose values are secret, matched
+// case-insensitively as "Key=Value" arguments, or torrc "Key Value" lines.
+var SecretKeys = []string{
+    "PrivateKey",
+    "ClientAuth",
+    "ClientAuthV3",
+    "HashedControlPassword",
+    "HashedControlSessionPassword",
+    "HTTPProxyAuthenticator",
+    "HTTPSProxyAuthenticator",
+    "Socks5ProxyPassword",
+    "SOCKS_PASSWORD",
+}
+
+var secretArgPattern, secretLinePattern = func() (*regexp.Regexp, *regexp.Regexp) {
+    keys := make([]string, 0, len(SecretKeys))
+    for _, v := range SecretKeys { keys = append(keys, regexp.QuoteMeta(v)) }
+    alternatives := strings.Join(keys, "|")
+
+    return regexp.MustCompile(`(?i)(^|[\s+-])(` + alternatives + `)=("(?:\\.|[^"\\])*"|\S*)`),
+           regexp.MustCompile(`(?i)^(\s*)(` + alternatives + `)\s+\S.*$`)
+}()
+
+// Returns a copy of the request lines with secrets masked, for logging. The
+// arguments of AUTHENTICATE are masked entirely, along with the key blobs of
+// ADD_ONION and ONION_CLIENT_AUTH_ADD, and the values of SecretKeys.
+func RedactRequest(lines []string) []string {
+    results := make([]string, 0, len(lines))
+    if len(lines) == 0 { return results }
+
+    args := strings.Split(lines[0], " ")
+    switch strings.ToUpper(strings.TrimPrefix(args[0], "+")) {
+    case COMMAND_AUTHENTICATE:
+        if len(args) > 1 { args = []string{args[0], REDACTED} }
+
+    case COMMAND_ADD_ONION:
+        // Key blobs follow the key type, except for generated keys.
+        if len(args) > 1 {
+            if parts := strings.SplitN(args[1], ":", 2); len(parts) == 2 && parts[0] != ONION_KEY_TYPE_NEW {
+                args[1] = parts[0] + ":" + REDACTED
+            }
+        }
+
+    case "ONION_CLIENT_AUTH_ADD":
+        if len(args) > 2 {
+            if parts := strings.SplitN(args[2], ":", 2); len(parts) == 2 {
+                args[2] = parts[0] + ":" + REDACTED
+            }
+        }
+    }
+    results = append(results, redactSecretKeys(strings.Join(args, " ")))
+
+    for _, v := range lines[1:] {
+        results = append(results, redactSecretKeys(v))
+    }
+    return results
+}
+
+// Returns a copy of the reply lines with the values of SecretKeys masked, for
+// logging.
+func RedactReply(lines []string) []string {
+    results := make([]string, 0, len(lines))
+    for _, v := range lines {
+        results = append(results, redactSecretKeys(v))
+    }
+    return results
+}
+
+func redactSecretKeys(ln string) string {
+    ln = secretLinePattern.ReplaceAllString(ln, "${1}${2} " + REDACTED)
+    return secretArgPattern.ReplaceAllString(ln, "${1}${2}=" + REDACTED)
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestRedactRequest(t *testing.T) {
+    tests := []struct {
+        lines, want []string
+    }{
+        {[]string{"AUTHENTICATE 0011aabb"}, []string{"AUTHENTICATE [REDACTED]"}},
+        {[]string{"AUTHENTICATE"}, []string{"AUTHENTICATE"}},
+        {[]string{"ADD_ONION ED25519-V3:c2VjcmV0 Flags=Detach Port=80 ClientAuth=bob:xyz"},
+         []string{"ADD_ONION ED25519-V3:[REDACTED] Flags=Detach Port=80 ClientAuth=[REDACTED]"}},
+        {[]string{"ADD_ONION NEW:BEST Port=80"}, []string{"ADD_ONION NEW:BEST Port=80"}},
+        {[]string{`SETCONF hashedcontrolpassword="16:AB" SocksPort=9050`},
+         []string{"SETCONF hashedcontrolpassword=[REDACTED] SocksPort=9050"}},
+        {[]string{"+LOADCONF", "SocksPort 9050", "HashedControlPassword 16:AB", "."},
+         []string{"+LOADCONF", "SocksPort 9050", "HashedControlPassword [REDACTED]", "."}},
+        {[]string{"GETCONF HashedControlPassword SocksPort"}, []string{"GETCONF HashedControlPassword SocksPort"}},
+    }
+    for _, v := range tests {
+        got := RedactRequest(v.lines)
+        if strings.Join(got, "\n") != strings.Join(v.want, "\n") {
+            t.Errorf("RedactRequest(%q) = %q, expected %q", v.lines, got, v.want)
+        }
+    }
+}
+
+func TestRedactReply(t *testing.T) {
+    got := RedactReply([]string{"250-ServiceID=abc", "250-PrivateKey=ED25519-V3:KEY", "250 OK"})
+    if got[0] != "250-ServiceID=abc" || got[1] != "250-PrivateKey=[REDACTED]" || got[2] != "250 OK" {
+        t.Fatalf("Unexpected redaction %q", got)
+    }
+}
+
+func TestSecretFormatting(t *testing.T) {
+    s := Secret("hunter2")
+    data, _ := json.Marshal(struct{ P Secret }{s})
+    out := fmt.Sprintf("%v %s %q %x %d %+v %#v ", s, s, s, s, s, s, s) + string(data) + NewRequest("AUTHENTICATE 1234").String()
+    if strings.Contains(out, "hunter2") || strings.Contains(out, "1234") { t.Fatalf("Secret formatted in clear: %s", out) }
+}
+
+func TestRedactSocksPassword(t *testing.T) {
+    line := "650 CIRC 5 BUILT $A~a PURPOSE=GENERAL SOCKS_USERNAME=\"alice\" SOCKS_PASSWORD=\"hunter2\""
+
+    redacted := RedactReply([]string{line})[0]
+    if strings.Contains(redacted, "hunter2") { t.Fatalf("Password not redacted: %s", redacted) }
+    if !strings.Contains(redacted, "alice") { t.Fatalf("Username redacted: %s", redacted) }
+
+    event, e := DecodeEvent(ResponseBuffer{EndReplyLine: EndReplyLine(line), RawLines: []string{line}})
+    if e != nil { t.Fatal(e) }
+
+    circ := event.(*CircuitEvent)
+    if circ.SocksPassword.Reveal() != "hunter2" { t.Fatalf("Unexpected password %q", circ.SocksPassword.Reveal()) }
+    if s := fmt.Sprintf("%v %+v", circ.SocksPassword, *circ); strings.Contains(s, "hunter2") {
+        t.Fatalf("Password formatted in clear: %s", s)
+    }
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "context"
+    "fmt"
+    "sync"
+)
+
+// The ConnectionState type describes the state of a Controller's connection to
+// the control socket.
+type ConnectionState int
+
+const (
+    // Not connected, either never connected or closed.
+    STATE_CLOSED ConnectionState = iota
+
+    // Dialing the control socket.
+    STATE_DIALING
+
+    // Connected, negotiating PROTOCOLINFO and authentication.
+    STATE_AUTHENTICATING
+
+    // Authenticated and ready to perform requests.
+    STATE_READY
+
+    // Connection lost, waiting to reconnect according to ReconnectPolicy.
+    STATE_RECONNECTING
+
+    // Connected, but heartbeats are going unanswered, see HeartbeatPolicy.
+    STATE_DEGRADED
+)
+
+func (s ConnectionState) String() string {
+    switch s {
+    case STATE_CLOSED:
+        return "Closed"
+    case STATE_DIALING:
+        return "Dialing"
+    case STATE_AUTHENTICATING:
+        return "Authenticating"
+    case STATE_READY:
+        return "Ready"
+    case STATE_RECONNECTING:
+        return "Reconnecting"
+    case STATE_DEGRADED:
+        return "Degraded"
+    }
+    return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// Function template for connection state change handlers, e holds the cause of
+// the transition when there is one, such as the error that closed the
+// connection.
+type StateHandler func(state ConnectionState, e error)
+
+// Identifies a handler registered with AddStateHandler().
+type StateHandlerID uint64
+
+type registeredStateHandler struct {
+    id      StateHandlerID
+    handler StateHandler
+}
+
+// Registers handler to be invoked on each connection state transition, until
+// removed with RemoveStateHandler(). Handlers are invoked from the goroutine
+// causing the transition, so they should not block for long periods of time.
+func (c *Controller) AddStateHandler(handler StateHandler) StateHandlerID {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+
+    c.stateHandlerCount++
+    id := StateHandlerID(c.stateHandlerCount)
+    c.stateHandlers = append(c.stateHandlers, registeredStateHandler{id, handler})
+    return id
+}
+
+// Removes the state handler registered as id. A transition already underway
+// may still invoke it once.
+func (c *Controller) RemoveStateHandler(id StateHandlerID) {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+
+    results := make([]registeredStateHandler, 0, len(c.stateHandlers))
+    for _, v := range c.stateHandlers {
+        if v.id != id { results = append(results, v) }
+    }
+    c.stateHandlers = results
+}
+
+// The StateChange type describes a connection state transition, as delivered
+// by WatchState().
+type StateChange struct {
+    State ConnectionState
+    Err   error
+}
+
+// Returns a channel receiving subsequent connection state transitions, with
+// room for size transitions. Transitions are dropped rather than blocking the
+// connection when the channel is full. Once ctx is done the watch is removed
+// and the channel closed.
+func (c *Controller) WatchState(ctx context.Context, size int) <-chan StateChange {
+    ch := make(chan StateChange, size)
+
+    var mutex sync.Mutex
+    closed := false
+
+    id := c.AddStateHandler(func(state ConnectionState, e error) {
+        mutex.Lock()
+        defer mutex.Unlock()
+        if closed { return }
+
+        select {
+            case ch<- StateChange{state, e}:
+            default:
+        }
+    })
+
+    context.AfterFunc(ctx, func() {
+        c.RemoveStateHandler(id)
+
+        mutex.Lock()
+        defer mutex.Unlock()
+        closed = true
+        close(ch)
+    })
+    return ch
+}
+
+// Returns the current connection state.
+func (c *Controller) State() ConnectionState {
+    c.stateMutex.Lock()
+    defer c.stateMutex.Unlock()
+    return c.state
+}
+
+// Transition to state, notifying registered handlers.
+func (c *Controller) setState(state ConnectionState, e error) {
+    c.stateMutex.Lock()
+    if c.state == state && e == nil {
+        c.stateMutex.Unlock()
+        return
+    }
+
+    // Once closed by Close(), stay closed whatever a reconnection attempt
+    // still in progress reports.
+    if c.state == STATE_CLOSED && state != STATE_CLOSED && c.stop != nil {
+        select {
+            case <-c.stop:
+                c.stateMutex.Unlock()
+                return
+            default:
+        }
+    }
+    c.state = state
+    c.isConnected = state == STATE_AUTHENTICATING || state == STATE_READY || state == STATE_DEGRADED
+
+    // Report the connection gone for good, or start over once connecting anew.
+    select {
+        case <-c.done:
+            if state != STATE_CLOSED {
+                c.done = make(chan struct{})
+                c.err  = nil
+            }
+        default:
+            if state == STATE_CLOSED {
+                c.err = e
+                if c.err == nil { c.err = ErrClosed }
+                close(c.done)
+            }
+    }
+
+    handlers := c.stateHandlers
+    c.stateMutex.Unlock()
+
+    c.logger().Info("Connection state changed.", "state", state.String())
+    for _, v := range handlers {
+        v.handler(state, e)
+    }
+}
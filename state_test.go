@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+
+package torc
+
+import (
+    "context"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRemoveStateHandler(t *testing.T) {
+    c := NewController("tcp", "127.0.0.1:9051")
+
+    var first, second atomic.Int32
+    id := c.AddStateHandler(func(ConnectionState, error) { first.Add(1) })
+    c.AddStateHandler(func(ConnectionState, error) { second.Add(1) })
+
+    c.setState(STATE_DIALING, nil)
+    c.RemoveStateHandler(id)
+    c.RemoveStateHandler(id)
+    c.setState(STATE_AUTHENTICATING, nil)
+
+    if n := first.Load(); n != 1 { t.Errorf("Expected removed handler invoked once, got %d", n) }
+    if n := second.Load(); n != 2 { t.Errorf("Expected remaining handler invoked twice, got %d", n) }
+}
+
+func TestWatchStateCancel(t *testing.T) {
+    c := NewController("tcp", "127.0.0.1:9051")
+
+    ctx, cancel := context.WithCancel(context.Background())
+    states := c.WatchState(ctx, 4)
+
+    c.setState(STATE_DIALING, nil)
+    if change := <-states; change.State != STATE_DIALING { t.Fatalf("Expected Dialing, got %s", change.State) }
+
+    cancel()
+    select {
+        case _, ok := <-states:
+            if ok { t.Fatal("Expected no further transitions") }
+        case <-time.After(time.Second):
+            t.Fatal("Channel not closed after cancelling")
+    }
+
+    c.stateMutex.Lock()
+    count := len(c.stateHandlers)
+    c.stateMutex.Unlock()
+    if count != 0 { t.Fatalf("Expected watch handler removed, %d remain", count) }
+
+    // Transitions after cancelling mustn't send on the closed channel.
+    c.setState(STATE_AUTHENTICATING, nil)
+}
@@ -0,0 +1,410 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// Returned, wrapped, by Subscribe when an event name isn't listed by Tor's
+// "GETINFO events/names".
+var ErrUnknownEvent = errors.New("unknown event")
+
+// The OverflowPolicy type selects what a Subscription does with an event when
+// its channel is full.
+type OverflowPolicy int
+
+const (
+    // Discard the oldest queued event to make room, the default.
+    OVERFLOW_DROP_OLDEST OverflowPolicy = iota
+
+    // Discard the new event.
+    OVERFLOW_DROP_NEWEST
+
+    // Wait for room. This holds up delivery to every other handler and
+    // subscription, though never command replies, until the Controller's
+    // event queue fills and drops events, see DroppedEvents().
+    OVERFLOW_BLOCK
+
+    // Replace a queued event sharing the new event's CoalesceKey, so only the
+    // latest event per key is kept.
+    OVERFLOW_COALESCE
+)
+
+// The default capacity of a Subscription's channel.
+const DEFAULT_SUBSCRIPTION_BUFFER = 64
+
+// The SubscribeOptions type configures how a Subscription queues its events,
+// see SubscribeWith().
+type SubscribeOptions struct {
+    // Capacity of the channel, defaults to DEFAULT_SUBSCRIPTION_BUFFER.
+    Buffer int
+
+    Policy OverflowPolicy
+
+    // Key of events to coalesce with OVERFLOW_COALESCE, defaults to the event
+    // type.
+    CoalesceKey func(Event) string
+
+    // Events not matched are discarded before being queued, and don't count
+    // as dropped. See ParseFilter().
+    Filter Filter
+}
+
+// The Subscription type represents interest in a set of asynchronous events,
+// as returned by Subscribe(). Tor stays subscribed to an event for as long as
+// any Subscription includes it. Events are queued on the channel returned by
+// Events() according to the subscription's OverflowPolicy.
+type Subscription struct {
+    c     *Controller
+    types []string
+    once  sync.Once
+
+    options SubscribeOptions
+    ch      chan Event
+    dropped atomic.Uint64
+
+    // Closed on unsubscribe, guards closing ch against delivery in progress.
+    done   chan struct{}
+    mutex  sync.RWMutex
+    closed bool
+
+    // Events awaiting room in ch with OVERFLOW_COALESCE, and their keys.
+    pending      []Event
+    pendingKeys  []string
+    pendingMutex sync.Mutex
+    wake         chan struct{}
+}
+
+func newSubscription(c *Controller, options SubscribeOptions, types []string) *Subscription {
+    if options.Buffer <= 0 { options.Buffer = DEFAULT_SUBSCRIPTION_BUFFER }
+    if options.CoalesceKey == nil { options.CoalesceKey = Event.EventType }
+
+    s := &Subscription{c: c, types: types, options: options}
+    s.ch   = make(chan Event, options.Buffer)
+    s.done = make(chan struct{})
+
+    if options.Policy == OVERFLOW_COALESCE {
+        s.wake = make(chan struct{}, 1)
+        go s.forward()
+    }
+    return s
+}
+
+// Returns the event names the subscription covers.
+func (s *Subscription) Types() []string {
+    return append([]string(nil), s.types...)
+}
+
+// Returns the channel events are delivered on, it's closed on unsubscribe.
+func (s *Subscription) Events() <-chan Event {
+    return s.ch
+}
+
+// Returns the number of events dropped, or replaced when coalescing, because
+// the channel was full.
+func (s *Subscription) Dropped() uint64 {
+    return s.dropped.Load()
+}
+
+// Queue event according to the overflow policy, if it passes the filter.
+func (s *Subscription) deliver(event Event) {
+    if s.options.Filter != nil && !s.options.Filter(event) { return }
+
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    if s.closed { return }
+
+    switch s.options.Policy {
+    case OVERFLOW_BLOCK:
+        select {
+            case s.ch<- event:
+            case <-s.done:
+        }
+
+    case OVERFLOW_DROP_NEWEST:
+        select {
+            case s.ch<- event:
+            default:
+                s.dropped.Add(1)
+        }
+
+    case OVERFLOW_COALESCE:
+        s.coalesce(event)
+
+    default:
+        for {
+            select {
+                case s.ch<- event:
+                    return
+                default:
+            }
+            select {
+                case <-s.ch:
+                    s.dropped.Add(1)
+                default:
+            }
+        }
+    }
+}
+
+// Queue event for forward(), replacing any pending event with the same key.
+func (s *Subscription) coalesce(event Event) {
+    key := s.options.CoalesceKey(event)
+
+    s.pendingMutex.Lock()
+    replaced := false
+    for i, v := range s.pendingKeys {
+        if v == key {
+            s.pending[i] = event
+            replaced = true
+            break
+        }
+    }
+    if replaced {
+        s.dropped.Add(1)
+    } else {
+        s.pending = append(s.pending, event)
+        s.pendingKeys = append(s.pendingKeys, key)
+    }
+    s.pendingMutex.Unlock()
+
+    select {
+        case s.wake<- struct{}{}:
+        default:
+    }
+}
+
+// Move coalesced events into the channel as room allows, until unsubscribed.
+func (s *Subscription) forward() {
+    for {
+        select {
+            case <-s.wake:
+            case <-s.done:
+                return
+        }
+
+        for {
+            s.pendingMutex.Lock()
+            if len(s.pending) == 0 {
+                s.pendingMutex.Unlock()
+                break
+            }
+            event := s.pending[0]
+            s.pending, s.pendingKeys = s.pending[1:], s.pendingKeys[1:]
+            s.pendingMutex.Unlock()
+
+            s.mutex.RLock()
+            if s.closed {
+                s.mutex.RUnlock()
+                return
+            }
+            select {
+                case s.ch<- event:
+                case <-s.done:
+            }
+            s.mutex.RUnlock()
+        }
+    }
+}
+
+// Stop delivery and close the channel.
+func (s *Subscription) close() {
+    close(s.done)
+
+    s.mutex.Lock()
+    s.closed = true
+    close(s.ch)
+    s.mutex.Unlock()
+}
+
+// Releases the subscription, see UnsubscribeContext().
+func (s *Subscription) Unsubscribe() error {
+    return s.UnsubscribeContext(context.Background())
+}
+
+// Releases the subscription and closes its channel, using ctx to bound the
+// SETEVENTS request sent when events are no longer wanted by any other
+// subscription. Calling it more than once has no further effect.
+func (s *Subscription) UnsubscribeContext(ctx context.Context) error {
+    var e error
+    s.once.Do(func() {
+        s.c.removeSubscriber(s)
+        s.close()
+        e = s.c.unsubscribe(ctx, s.types)
+    })
+    return e
+}
+
+// Subscribes to events with default options, see SubscribeWith().
+func (c *Controller) Subscribe(events ...string) (*Subscription, error) {
+    return c.SubscribeWith(context.Background(), SubscribeOptions{}, events...)
+}
+
+// Subscribes to events with default options, as with Subscribe(), using ctx to
+// bound the requests made.
+func (c *Controller) SubscribeContext(ctx context.Context, events ...string) (*Subscription, error) {
+    return c.SubscribeWith(ctx, SubscribeOptions{}, events...)
+}
+
+// Subscribes to events on behalf of one of several independent components,
+// using ctx to bound the requests made. Subscriptions are reference counted,
+// and Tor is sent a single SETEVENTS for the union of all of them whenever it
+// changes. Names are validated against "GETINFO events/names" first. Events
+// matching options.Filter are delivered on the subscription's Events()
+// channel, queued as configured by options, as well as to handlers registered
+// with AddEventHandler.
+//
+// Calling SetEvents directly replaces the whole set, so it shouldn't be mixed
+// with Subscribe.
+func (c *Controller) SubscribeWith(ctx context.Context, options SubscribeOptions, events ...string) (*Subscription, error) {
+    c.subscribeMutex.Lock()
+    defer c.subscribeMutex.Unlock()
+
+    types := make([]string, 0, len(events))
+    for _, v := range events {
+        v = strings.ToUpper(v)
+        if !__contains(types, v) { types = append(types, v) }
+    }
+
+    if e := c.validateEvents(ctx, types); e != nil {
+        return nil, e
+    }
+
+    for _, v := range types { c.subscriptions[v]++ }
+
+    if e := c.applySubscriptions(ctx); e != nil {
+        c.release(types)
+        return nil, e
+    }
+
+    s := newSubscription(c, options, types)
+
+    c.handlersMutex.Lock()
+    c.subscribers = append(c.subscribers, s)
+    c.handlersMutex.Unlock()
+
+    return s, nil
+}
+
+// Stop dispatching events to s.
+func (c *Controller) removeSubscriber(s *Subscription) {
+    c.handlersMutex.Lock()
+    defer c.handlersMutex.Unlock()
+
+    results := make([]*Subscription, 0, len(c.subscribers))
+    for _, v := range c.subscribers {
+        if v != s { results = append(results, v) }
+    }
+    c.subscribers = results
+}
+
+func (c *Controller) unsubscribe(ctx context.Context, types []string) error {
+    c.subscribeMutex.Lock()
+    defer c.subscribeMutex.Unlock()
+
+    c.release(types)
+
+    e := c.applySubscriptions(ctx)
+    if e != nil && !c.IsConnected() {
+        // Make sure the released events aren't restored on reconnect.
+        c.recordEvents(c.subscribedEvents())
+    }
+    return e
+}
+
+// Drop a reference to each of types.
+func (c *Controller) release(types []string) {
+    for _, v := range types {
+        if c.subscriptions[v]--; c.subscriptions[v] <= 0 {
+            delete(c.subscriptions, v)
+        }
+    }
+}
+
+// Returns the sorted union of all subscriptions.
+func (c *Controller) subscribedEvents() []string {
+    results := make([]string, 0, len(c.subscriptions))
+    for k := range c.subscriptions {
+        results = append(results, k)
+    }
+    sort.Strings(results)
+    return results
+}
+
+// Send SETEVENTS for the union of all subscriptions, unless Tor is already
+// subscribed to exactly those.
+func (c *Controller) applySubscriptions(ctx context.Context) error {
+    events := c.subscribedEvents()
+
+    c.restoreMutex.Lock()
+    active := append([]string(nil), c.activeEvents...)
+    c.restoreMutex.Unlock()
+
+    sort.Strings(active)
+    if strings.Join(active, " ") == strings.Join(events, " ") {
+        return nil
+    }
+
+    _, e := c.SetEventsContext(ctx, events)
+    return e
+}
+
+// Check events against those supported by Tor, fetched once per connection.
+// Tor versions without "events/names" aren't checked.
+func (c *Controller) validateEvents(ctx context.Context, events []string) error {
+    c.stateMutex.Lock()
+    known := c.eventNames
+    c.stateMutex.Unlock()
+
+    if known == nil {
+        r, e := c.GetInfoContext(ctx, []string{"events/names"})
+        var reply *ReplyError
+        if errors.As(e, &reply) {
+            return nil
+        } else if e != nil {
+            return e
+        }
+
+        names, _ := r.ValueOf("events/names")
+        known = strings.Fields(names)
+
+        c.stateMutex.Lock()
+        c.eventNames = known
+        c.stateMutex.Unlock()
+    }
+
+    for _, v := range events {
+        if !__contains(known, v) {
+            return fmt.Errorf("%w: %s", ErrUnknownEvent, v)
+        }
+    }
+    return nil
+}
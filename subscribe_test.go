@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Answers as fakeReply, supporting CIRC and BW events, with "GETINFO flood"
+// preceded by 200 BW events.
+func eventReply(line string) []string {
+    switch {
+    case strings.HasPrefix(line, "GETINFO events/names"):
+        return []string{"250-events/names=CIRC BW", "250 OK"}
+    case strings.HasPrefix(line, "GETINFO flood"):
+        lines := make([]string, 0)
+        for i := 0; i < 200; i++ { lines = append(lines, fmt.Sprintf("650 BW %d 0", i)) }
+        return append(lines, "250-flood=1", "250 OK")
+    }
+    return fakeReply(line)
+}
+
+func TestSubscribeReferenceCounting(t *testing.T) {
+    var mutex sync.Mutex
+    setevents := make([]string, 0)
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "SETEVENTS") {
+            mutex.Lock()
+            setevents = append(setevents, line)
+            mutex.Unlock()
+        }
+        return eventReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    a, e := c.Subscribe("CIRC", "BW")
+    if e != nil { t.Fatal(e) }
+    b, e := c.Subscribe("circ")
+    if e != nil { t.Fatal(e) }
+    if _, e := c.Subscribe("NOPE"); !errors.Is(e, ErrUnknownEvent) { t.Fatalf("Expected ErrUnknownEvent, got %v", e) }
+
+    a.Unsubscribe()
+    a.Unsubscribe()
+    b.Unsubscribe()
+
+    mutex.Lock()
+    defer mutex.Unlock()
+    if got := strings.Join(setevents, "|"); got != "SETEVENTS BW CIRC|SETEVENTS CIRC|SETEVENTS" {
+        t.Fatalf("Unexpected SETEVENTS requests %s", got)
+    }
+}
+
+func TestSlowSubscriberDoesNotDelayReplies(t *testing.T) {
+    c, _ := newFakeTor(t, eventReply)
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    ctx := context.Background()
+    blocked, _ := c.SubscribeWith(ctx, SubscribeOptions{Buffer: 1, Policy: OVERFLOW_BLOCK}, EVENT_BW)
+    oldest, _  := c.SubscribeWith(ctx, SubscribeOptions{Buffer: 10}, EVENT_BW)
+    newest, _  := c.SubscribeWith(ctx, SubscribeOptions{Buffer: 10, Policy: OVERFLOW_DROP_NEWEST}, EVENT_BW)
+
+    // Nothing reads the blocked subscription, yet replies keep arriving.
+    for i := 0; i < 3; i++ {
+        ctx, cancel := context.WithTimeout(ctx, time.Second)
+        _, e := c.GetInfoContext(ctx, []string{"flood"})
+        cancel()
+        if e != nil { t.Fatal(e) }
+    }
+
+    <-blocked.Events()
+    blocked.Unsubscribe()
+
+    // All 600 events reach the other subscriptions once the block is gone.
+    deadline := time.Now().Add(time.Second)
+    for (oldest.Dropped() != 590 || newest.Dropped() != 590) && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond)
+    }
+    if oldest.Dropped() != 590 || newest.Dropped() != 590 {
+        t.Fatalf("Expected 590 dropped events, got %d and %d", oldest.Dropped(), newest.Dropped())
+    }
+    if v := (<-oldest.Events()).(*BandwidthEvent).BytesRead; v != 190 { t.Errorf("Expected oldest kept to be 190, got %d", v) }
+    if v := (<-newest.Events()).(*BandwidthEvent).BytesRead; v != 0 { t.Errorf("Expected newest kept to be 0, got %d", v) }
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+    c, _ := newFakeTor(t, func(line string) []string {
+        if strings.HasPrefix(line, "GETINFO go") {
+            return []string{"650 CIRC 1 LAUNCHED", "650 CIRC 1 BUILT PURPOSE=GENERAL", "650 CIRC 2 BUILT PURPOSE=HS_SERVICE_REND", "250-go=1", "250 OK"}
+        }
+        return eventReply(line)
+    })
+    if e := c.Connect(); e != nil { t.Fatal(e) }
+
+    filter, e := ParseFilter(`circ.status == BUILT && circ.purpose == HS_SERVICE_REND`)
+    if e != nil { t.Fatal(e) }
+    s, e := c.SubscribeWith(context.Background(), SubscribeOptions{Buffer: 1, Policy: OVERFLOW_DROP_NEWEST, Filter: filter}, EVENT_CIRC)
+    if e != nil { t.Fatal(e) }
+
+    if _, e := c.GetInfo([]string{"go"}); e != nil { t.Fatal(e) }
+    event := <-s.Events()
+    if id := event.(*CircuitEvent).CircuitID; id != "2" || s.Dropped() != 0 {
+        t.Fatalf("Expected only circuit 2 with nothing dropped, got %s with %d dropped", id, s.Dropped())
+    }
+
+    s.Unsubscribe()
+    if _, ok := <-s.Events(); ok { t.Fatal("Events() not closed by Unsubscribe()") }
+}
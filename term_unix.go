@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package torc
+
+import (
+    "os"
+    "syscall"
+    "unsafe"
+)
+
+// Disable echo on terminal f, returns a function restoring the previous state.
+func disableEcho(f *os.File) (func(), error) {
+    var state syscall.Termios
+    if e := ioctlTermios(f, ioctlGetTermios, &state); e != nil { return nil, e }
+
+    silent := state
+    silent.Lflag &^= syscall.ECHO
+    silent.Lflag |= syscall.ICANON | syscall.ISIG
+    if e := ioctlTermios(f, ioctlSetTermios, &silent); e != nil { return nil, e }
+
+    return func() { ioctlTermios(f, ioctlSetTermios, &state) }, nil
+}
+
+// Perform the termios ioctl request on f. The descriptor is borrowed through
+// SyscallConn, as Fd() would switch f to blocking mode and stop reads from
+// being interrupted.
+func ioctlTermios(f *os.File, request uintptr, t *syscall.Termios) error {
+    conn, e := f.SyscallConn()
+    if e != nil { return e }
+
+    var errno syscall.Errno
+    e = conn.Control(func(fd uintptr) {
+        _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(t)))
+    })
+    if e != nil { return e }
+    if errno != 0 { return errno }
+    return nil
+}
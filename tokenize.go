@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package torc
+
+import (
+    "strings"
+)
+
+// The ReplyArg type is a single argument of a reply line, as tokenized by
+// ParseReplyArgs(). Key is empty for positional arguments.
+type ReplyArg struct {
+    Key    string
+    Value  string
+    Quoted bool
+}
+
+// The ReplyArgs type holds the arguments of a reply line, in the order they
+// were received.
+type ReplyArgs []ReplyArg
+
+// Tokenizes reply text into positional arguments and "Key=Value" keyword
+// arguments separated by spaces. Values given as QuotedStrings are unescaped.
+// Keys are made of letters, digits, "_", "-" and "/", anything else containing
+// "=" is kept as a positional argument.
+func ParseReplyArgs(text string) ReplyArgs {
+    results := make(ReplyArgs, 0)
+
+    for i := 0; i < len(text); {
+        if text[i] == ' ' { i++; continue }
+
+        // Look for a keyword prefix, otherwise treat as a positional argument.
+        arg := ReplyArg{}
+        j := i
+        for j < len(text) && __is_keyword_char(text[j]) { j++ }
+        if j > i && j < len(text) && text[j] == '=' {
+            arg.Key = text[i:j]
+            i = j + 1
+        }
+
+        if i < len(text) && text[i] == '"' {
+            arg.Value, i = __unquote(text, i)
+            arg.Quoted = true
+        } else {
+            j = i
+            for j < len(text) && text[j] != ' ' { j++ }
+            arg.Value, i = text[i:j], j
+        }
+
+        results = append(results, arg)
+    }
+
+    return results
+}
+
+// Returns the positional arguments.
+func (a ReplyArgs) Positional() []string {
+    results := make([]string, 0)
+    for _, v := range a {
+        if v.Key == "" { results = append(results, v.Value) }
+    }
+    return results
+}
+
+// Returns the positional argument at index i, false if there are fewer.
+func (a ReplyArgs) Arg(i int) (string, bool) {
+    args := a.Positional()
+    if i < 0 || i >= len(args) { return "", false }
+    return args[i], true
+}
+
+// Returns the value of the first keyword argument named key, false if there's
+// none.
+func (a ReplyArgs) Value(key string) (string, bool) {
+    for _, v := range a {
+        if v.Key == key { return v.Value, true }
+    }
+    return "", false
+}
+
+// Returns the values of every keyword argument named key.
+func (a ReplyArgs) Values(key string) []string {
+    results := make([]string, 0)
+    for _, v := range a {
+        if v.Key == key { results = append(results, v.Value) }
+    }
+    return results
+}
+
+// Returns true if name is given either as a keyword argument, or as a bare
+// positional argument such as an optional flag.
+func (a ReplyArgs) Has(name string) bool {
+    for _, v := range a {
+        if v.Key == name || v.Key == "" && !v.Quoted && v.Value == name { return true }
+    }
+    return false
+}
+
+// Returns keyword arguments as a map, the last value winning for repeated keys.
+func (a ReplyArgs) Map() map[string]string {
+    results := make(map[string]string)
+    for _, v := range a {
+        if v.Key != "" { results[v.Key] = v.Value }
+    }
+    return results
+}
+
+// Splits a single "Key=Value" reply line, such as returned by GETINFO and
+// GETCONF, where the value runs to the end of the line. A value given as a
+// single QuotedString is unescaped. Lines with no "=" are returned as a key
+// without a value, ok is false in that case.
+func ParseKeyValue(text string) (key string, value string, ok bool) {
+    i := strings.IndexByte(text, '=')
+    if i == -1 { return text, "", false }
+
+    key, value = text[:i], text[i+1:]
+    if strings.HasPrefix(value, "\"") {
+        if unquoted, j := __unquote(value, 0); j == len(value) && value[j-1] == '"' {
+            value = unquoted
+        }
+    }
+    return key, value, true
+}
+
+// Helpers ---------------------------------------------------------------------
+func __is_keyword_char(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+           c == '_' || c == '-' || c == '/'
+}
+
+// Decodes the QuotedString starting at text[i], returns the unescaped value and
+// the index following the closing quote.
+func __unquote(text string, i int) (string, int) {
+    var result strings.Builder
+    for i++; i < len(text); i++ {
+        switch c := text[i]; c {
+        case '"':
+            return result.String(), i + 1
+
+        case '\\':
+            if i+1 >= len(text) { return result.String(), i + 1 }
+            i++
+            switch text[i] {
+            case 'n': result.WriteByte('\n')
+            case 'r': result.WriteByte('\r')
+            case 't': result.WriteByte('\t')
+            case '0', '1', '2', '3', '4', '5', '6', '7':
+                // Up to three octal digits.
+                n, j := 0, i
+                for ; j < len(text) && j < i+3 && text[j] >= '0' && text[j] <= '7'; j++ {
+                    n = n*8 + int(text[j]-'0')
+                }
+                result.WriteByte(byte(n))
+                i = j - 1
+            default:
+                result.WriteByte(text[i])
+            }
+
+        default:
+            result.WriteByte(c)
+        }
+    }
+    return result.String(), i
+}
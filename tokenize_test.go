@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2015 Tom Swindell (t.swindell@rubyx.co.uk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in
+ * all copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+
+package torc
+
+import (
+    "testing"
+)
+
+func TestParseReplyArgs(t *testing.T) {
+    args := ParseReplyArgs(` METHODS=COOKIE,SAFECOOKIE COOKIEFILE="/a \"b\"\\c" some-key=1 POS "q w" FLAG`)
+
+    if v, _ := args.Value("COOKIEFILE"); v != `/a "b"\c` { t.Errorf("Unexpected COOKIEFILE %q", v) }
+    if v, ok := args.Value("some-key"); !ok || v != "1" { t.Errorf("Unexpected some-key %q", v) }
+    if v := args.Positional(); len(v) != 3 || v[0] != "POS" || v[1] != "q w" || v[2] != "FLAG" {
+        t.Errorf("Unexpected positional arguments %q", v)
+    }
+    if !args.Has("FLAG") || args.Has("q w") { t.Error("Has() matched a quoted value") }
+}
+
+func TestParseKeyValue(t *testing.T) {
+    tests := []struct {
+        text, key, value string
+        ok               bool
+    }{
+        {"Key", "Key", "", false},
+        {"Key=", "Key", "", true},
+        {`K="a b"`, "K", "a b", true},
+        {`K="a" "b"`, "K", `"a" "b"`, true},
+        {"K=a=b", "K", "a=b", true},
+    }
+    for _, v := range tests {
+        key, value, ok := ParseKeyValue(v.text)
+        if key != v.key || value != v.value || ok != v.ok {
+            t.Errorf("ParseKeyValue(%q) = %q, %q, %v", v.text, key, value, ok)
+        }
+    }
+}
+
+// Values encoded by EncodeValue must come back unchanged from ParseReplyArgs.
+func TestEncodeValueRoundTrip(t *testing.T) {
+    values := []string{"", "plain", "two words", `"quoted"`, `back\slash`, "tab\there", "a=b", "\\\"", "ünïcode"}
+    for _, v := range values {
+        encoded, e := EncodeValue(v)
+        if e != nil { t.Fatalf("EncodeValue(%q): %v", v, e) }
+
+        args := ParseReplyArgs("KEY=" + encoded)
+        if got, ok := args.Value("KEY"); !ok || got != v {
+            t.Errorf("Round trip of %q via %s gave %q", v, encoded, got)
+        }
+    }
+}
@@ -25,8 +25,6 @@ package torc
 
 import (
     "fmt"
-    "log"
-    "runtime"
     "strconv"
     "strings"
 )
@@ -42,20 +40,51 @@ func elidePath(source string, maxlen int) string {
     return source
 }
 
-func _log(t, m string, v ...interface{}) {
-    pc, _, ln, _ := runtime.Caller(2)
-    fn := runtime.FuncForPC(pc).Name()
-    log.Printf(fn + ":" + strconv.Itoa(ln) + " -- " + t + " -- " + m + "\n", v...)
+// The Logger interface describes the structured logger a Controller writes to,
+// with key-value pairs following the message. It's satisfied by *slog.Logger,
+// e.g. controller.Logger = slog.Default()
+type Logger interface {
+    Debug(msg string, args ...any)
+    Info(msg string, args ...any)
+    Warn(msg string, args ...any)
+    Error(msg string, args ...any)
 }
 
-func LogInfo(m string, v ...interface{}) { _log("INFO", m, v...) }
-func LogWarn(m string, v ...interface{}) { _log("WARN", m, v...) }
-func LogError(m string, v ...interface{}) { _log("ERROR", m, v...) }
+// A Logger discarding everything, used when none is set.
+type nopLogger struct {}
 
-var COMMS_LOGGING = false
-func LogComms(dir string, data LineBuffer) {
-    if !COMMS_LOGGING { return }
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
 
-    _log("COMMS", "\n " + dir + " " + strings.Join(strings.Split(string(data.Normalize()), "\r\n")[0:len(data)], "\n " + dir + " "))
+// Logs lines sent or received on the control socket at debug level, with
+// secrets masked. Sent lines are tagged with their command, received replies
+// with their status.
+func logComms(l Logger, direction string, lines []string) {
+    if _, ok := l.(nopLogger); ok || len(lines) == 0 { return }
+
+    if direction == COMMS_SEND {
+        lines = RedactRequest(lines)
+    } else {
+        lines = RedactReply(lines)
+    }
+
+    first := strings.TrimPrefix(lines[0], "+")
+    if direction == COMMS_SEND {
+        l.Debug("Control message", "direction", direction,
+                                   "command", strings.SplitN(first, " ", 2)[0],
+                                   "lines", lines)
+    } else {
+        status, _ := strconv.Atoi(first[:min(3, len(first))])
+        l.Debug("Control message", "direction", direction,
+                                   "status", status,
+                                   "lines", lines)
+    }
 }
 
+// Directions reported by wire logging.
+const (
+    COMMS_SEND = "send"
+    COMMS_RECV = "recv"
+)